@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"slices"
+
+	"messaggio/model"
+)
+
+// keyPrefix отличает ключи messaggio в логах и сканерах секретов.
+const keyPrefix = "msg_"
+
+// GenerateKey создаёт новый API-ключ и возвращает его вместе с хэшем для хранения.
+func GenerateKey() (key, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", err
+	}
+
+	key = keyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	return key, HashKey(key), nil
+}
+
+// HashKey возвращает hex(SHA-256) ключа. Ключи случайные и длинные, поэтому соль не нужна.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Владельцы сообщений разных видов учётных данных не пересекаются: имя ключа и subject JWT
+// получают префикс, поэтому JWT с sub, равным имени ключа или admin, не видит чужих сообщений.
+const (
+	// AdminOwner — владелец сообщений, созданных статическим ключом администратора
+	AdminOwner = "admin"
+
+	keyOwnerPrefix = "key:"
+	jwtOwnerPrefix = "jwt:"
+)
+
+// KeyOwner возвращает владельца сообщений API-ключа с именем name.
+func KeyOwner(name string) string {
+	return keyOwnerPrefix + name
+}
+
+// JWTOwner возвращает владельца сообщений JWT с subject sub.
+func JWTOwner(sub string) string {
+	return jwtOwnerPrefix + sub
+}
+
+// Principal — вызывающий, прошедший аутентификацию. Owner записывается в созданные сообщения,
+// пустой Owner бывает только при выключенной аутентификации.
+type Principal struct {
+	Owner  string
+	Scopes []string
+
+	// DailyQuota — квота ключа, 0 — квота по умолчанию
+	DailyQuota int
+}
+
+// Has сообщает, есть ли у вызывающего область scope. Область admin включает все остальные.
+func (p Principal) Has(scope string) bool {
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, model.ScopeAdmin)
+}
+
+// Admin сообщает, что вызывающему доступны все сообщения, а не только свои.
+func (p Principal) Admin() bool {
+	return p.Has(model.ScopeAdmin)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
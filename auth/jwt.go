@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token expired")
+)
+
+// IsJWT отличает JWT от API-ключа: JWT состоит из трёх частей через точку.
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// ParseJWT проверяет JWT с подписью HS256 и возвращает вызывающего из claims:
+// sub — владелец сообщений (с префиксом jwt:), scope — области доступа через пробел, exp и nbf проверяются, если заданы.
+func ParseJWT(token, secret string, now time.Time) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return Principal{}, ErrInvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, ErrInvalidToken
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return Principal{}, ErrInvalidToken
+	}
+
+	var claims struct {
+		Sub   string `json:"sub"`
+		Scope string `json:"scope"`
+		Exp   int64  `json:"exp"`
+		Nbf   int64  `json:"nbf"`
+	}
+	if err = decodeSegment(parts[1], &claims); err != nil || claims.Sub == "" {
+		return Principal{}, ErrInvalidToken
+	}
+	if (claims.Exp != 0 && now.Unix() >= claims.Exp) || (claims.Nbf != 0 && now.Unix() < claims.Nbf) {
+		return Principal{}, ErrExpiredToken
+	}
+
+	return Principal{
+		Owner:  JWTOwner(claims.Sub),
+		Scopes: strings.Fields(claims.Scope),
+	}, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
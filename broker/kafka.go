@@ -0,0 +1,254 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	log "github.com/sirupsen/logrus"
+	"messaggio/config"
+	"messaggio/model"
+)
+
+// Kafka публикует сообщения в топики по приоритету и читает их отдельными reader'ами,
+// чтобы срочные сообщения не ждали за накопившимися обычными.
+type Kafka struct {
+	cfg        config.Broker
+	writer     *kafka.Writer
+	readers    map[string]*kafka.Reader
+	deadLetter *kafka.Writer
+	selector   *prioritySelector[fetched]
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type fetched struct {
+	message kafka.Message
+	err     error
+}
+
+func NewKafka(cfg config.Broker) (*Kafka, error) {
+	startOffset, err := parseStartOffset(cfg.StartOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	topics := []kafka.TopicConfig{{
+		Topic:             cfg.DeadLetterTopic,
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	}}
+	for _, p := range priorities {
+		topics = append(topics, kafka.TopicConfig{
+			Topic:             priorityTopic(cfg.Topic, p),
+			NumPartitions:     cfg.Partitions,
+			ReplicationFactor: 1,
+		})
+	}
+	if err = createTopics(cfg.KafkaAddr, topics...); err != nil {
+		return nil, err
+	}
+
+	// Топик задаётся в каждом сообщении по его приоритету
+	writer := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:      []string{cfg.KafkaAddr},
+		Balancer:     &kafka.Hash{},
+		BatchSize:    cfg.BatchSize,
+		BatchTimeout: cfg.BatchTimeout,
+		Logger:       log.StandardLogger(),
+		ErrorLogger:  log.StandardLogger(),
+	})
+	deadLetter := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:     []string{cfg.KafkaAddr},
+		Topic:       cfg.DeadLetterTopic,
+		Logger:      log.StandardLogger(),
+		ErrorLogger: log.StandardLogger(),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Kafka{
+		cfg:        cfg,
+		writer:     writer,
+		readers:    make(map[string]*kafka.Reader, len(priorities)),
+		deadLetter: deadLetter,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+
+	queues := make([]chan fetched, len(priorities))
+	for i, p := range priorities {
+		topic := priorityTopic(cfg.Topic, p)
+		reader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:     []string{cfg.KafkaAddr},
+			Topic:       topic,
+			GroupID:     cfg.GroupID,
+			StartOffset: startOffset,
+			MinBytes:    10e3, // 10KB
+			MaxBytes:    10e6, // 10MB
+			MaxWait:     500 * time.Millisecond,
+		})
+		b.readers[topic] = reader
+
+		queues[i] = make(chan fetched)
+		go b.fetch(reader, queues[i])
+	}
+	b.selector = newPrioritySelector(queues, cfg.PriorityWeight)
+
+	log.Tracef("kafka %s, topic %s, group %s", cfg.KafkaAddr, cfg.Topic, cfg.GroupID)
+
+	return b, nil
+}
+
+func parseStartOffset(offset string) (int64, error) {
+	switch offset {
+	case "", "first":
+		return kafka.FirstOffset, nil
+	case "last":
+		return kafka.LastOffset, nil
+	default:
+		return 0, fmt.Errorf("unknown start offset %q", offset)
+	}
+}
+
+// createTopics создаёт топики с нужным числом партиций, если их ещё нет.
+// Число партиций ограничивает количество одновременно работающих consumer'ов в группе.
+func createTopics(addr string, topics ...kafka.TopicConfig) error {
+	conn, err := kafka.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	controller, err := conn.Controller()
+	if err != nil {
+		return err
+	}
+
+	controllerConn, err := kafka.Dial("tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+	if err != nil {
+		return err
+	}
+	defer controllerConn.Close()
+
+	return controllerConn.CreateTopics(topics...)
+}
+
+// Close дожидается отправки буферизованных сообщений writer'а и закрывает соединения.
+func (b *Kafka) Close() {
+	b.cancel()
+	_ = b.writer.Close()
+	for _, reader := range b.readers {
+		_ = reader.Close()
+	}
+	_ = b.deadLetter.Close()
+}
+
+func (b *Kafka) Send(ctx context.Context, msg []model.Message) error {
+	var messages []kafka.Message
+	for _, m := range msg {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, kafka.Message{
+			Topic: priorityTopic(b.cfg.Topic, m.Priority),
+			Key:   []byte(strconv.Itoa(m.ID)),
+			Value: data,
+		})
+	}
+
+	return b.writer.WriteMessages(ctx, messages...)
+}
+
+// fetch читает топик одного приоритета и передаёт сообщения в Recv по одному,
+// поэтому без чтения из queue reader не уходит вперёд.
+func (b *Kafka) fetch(reader *kafka.Reader, queue chan<- fetched) {
+	for {
+		m, err := reader.FetchMessage(b.ctx)
+		if b.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			if kafkaError, ok := err.(kafka.Error); ok && kafkaError.Temporary() {
+				log.Printf("Temporary error while fetching message: %v, retrying...", err)
+			} else {
+				select {
+				case queue <- fetched{err: err}:
+				case <-b.ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-b.ctx.Done():
+				return
+			case <-time.After(1 * time.Second):
+			}
+			continue
+		}
+
+		select {
+		case queue <- fetched{message: m}:
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+// Recv читает следующее сообщение без фиксации offset'а, для этого нужно вызвать Commit.
+// Топики приоритетов читаются с весами PriorityWeight.
+func (b *Kafka) Recv(ctx context.Context) (Delivery, error) {
+	var d Delivery
+	f, err := b.selector.next(ctx, b.ctx.Done())
+	if err != nil {
+		return d, err
+	}
+	if f.err != nil {
+		return d, f.err
+	}
+
+	m := f.message
+	d = Delivery{
+		Key:       m.Key,
+		Value:     m.Value,
+		Topic:     m.Topic,
+		Partition: m.Partition,
+		Offset:    m.Offset,
+	}
+	if err = json.Unmarshal(m.Value, &d.Message); err != nil {
+		return d, fmt.Errorf("%w: %s", ErrMalformed, err)
+	}
+	return d, nil
+}
+
+func (b *Kafka) Commit(ctx context.Context, d Delivery) error {
+	reader, ok := b.readers[d.Topic]
+	if !ok {
+		return fmt.Errorf("unknown topic %q", d.Topic)
+	}
+
+	return reader.CommitMessages(ctx, kafka.Message{
+		Topic:     d.Topic,
+		Partition: d.Partition,
+		Offset:    d.Offset,
+	})
+}
+
+// DeadLetter перекладывает исходное сообщение в dead-letter топик вместе с причиной ошибки.
+func (b *Kafka) DeadLetter(ctx context.Context, d Delivery, reason error) error {
+	return b.deadLetter.WriteMessages(ctx, kafka.Message{
+		Key:   d.Key,
+		Value: d.Value,
+		Headers: []kafka.Header{
+			{Key: "error", Value: []byte(reason.Error())},
+			{Key: "topic", Value: []byte(d.Topic)},
+			{Key: "partition", Value: []byte(strconv.Itoa(d.Partition))},
+			{Key: "offset", Value: []byte(strconv.FormatInt(d.Offset, 10))},
+		},
+	})
+}
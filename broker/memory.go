@@ -0,0 +1,112 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"messaggio/config"
+	"messaggio/model"
+)
+
+const memoryBuffer = 1024
+
+var errClosed = errors.New("broker closed")
+
+// Memory — брокер на каналах внутри процесса для локальной разработки и тестов.
+// Сообщения не переживают перезапуск, Commit ничего не делает.
+type Memory struct {
+	cfg      config.Broker
+	queues   []chan Delivery
+	selector *prioritySelector[Delivery]
+	closed   chan struct{}
+	once     sync.Once
+
+	mu          sync.Mutex
+	offset      int64
+	deadLetters []Delivery
+}
+
+func NewMemory(cfg config.Broker) *Memory {
+	queues := make([]chan Delivery, len(priorities))
+	for i := range queues {
+		queues[i] = make(chan Delivery, memoryBuffer)
+	}
+
+	return &Memory{
+		cfg:      cfg,
+		queues:   queues,
+		selector: newPrioritySelector(queues, cfg.PriorityWeight),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (b *Memory) Close() {
+	b.once.Do(func() {
+		close(b.closed)
+	})
+}
+
+func (b *Memory) Send(ctx context.Context, msg []model.Message) error {
+	for _, m := range msg {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+
+		b.mu.Lock()
+		d := Delivery{
+			Key:    []byte(strconv.Itoa(m.ID)),
+			Value:  data,
+			Topic:  priorityTopic(b.cfg.Topic, m.Priority),
+			Offset: b.offset,
+		}
+		b.offset++
+		b.mu.Unlock()
+
+		select {
+		case b.queues[priorityIndex(m.Priority)] <- d:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-b.closed:
+			return errClosed
+		}
+	}
+	return nil
+}
+
+func (b *Memory) Recv(ctx context.Context) (Delivery, error) {
+	d, err := b.selector.next(ctx, b.closed)
+	if err != nil {
+		return d, err
+	}
+
+	if err = json.Unmarshal(d.Value, &d.Message); err != nil {
+		return d, fmt.Errorf("%w: %s", ErrMalformed, err)
+	}
+	return d, nil
+}
+
+func (b *Memory) Commit(context.Context, Delivery) error {
+	return nil
+}
+
+func (b *Memory) DeadLetter(_ context.Context, d Delivery, reason error) error {
+	log.Warnf("message moved to dead letter: %s", reason)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deadLetters = append(b.deadLetters, d)
+	return nil
+}
+
+// DeadLetters возвращает сообщения, отправленные в dead letter.
+func (b *Memory) DeadLetters() []Delivery {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Delivery(nil), b.deadLetters...)
+}
@@ -0,0 +1,151 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"messaggio/config"
+	"messaggio/model"
+)
+
+func TestMemory(t *testing.T) {
+	tests := []struct {
+		name   string
+		weight int
+		send   []model.Message
+
+		wantIDs    []int
+		wantTopics []string
+	}{
+		{
+			name:       "round trip",
+			weight:     3,
+			send:       []model.Message{{ID: 1, Content: "hello", From: "a", To: "b"}},
+			wantIDs:    []int{1},
+			wantTopics: []string{"messages"},
+		},
+		{
+			name:       "fifo within priority",
+			weight:     3,
+			send:       []model.Message{{ID: 1}, {ID: 2}, {ID: 3}},
+			wantIDs:    []int{1, 2, 3},
+			wantTopics: []string{"messages", "messages", "messages"},
+		},
+		{
+			// При таком весе низкий приоритет выбирается раньше высокого с вероятностью 2^-20
+			name:   "higher priority first",
+			weight: 1 << 20,
+			send: []model.Message{
+				{ID: 1, Priority: model.PriorityLow},
+				{ID: 2, Priority: model.PriorityNormal},
+				{ID: 3, Priority: model.PriorityHigh},
+			},
+			wantIDs:    []int{3, 2, 1},
+			wantTopics: []string{"messages.high", "messages", "messages.low"},
+		},
+		{
+			name:       "unknown priorities clamped",
+			weight:     1 << 20,
+			send:       []model.Message{{ID: 1, Priority: -5}, {ID: 2, Priority: 5}},
+			wantIDs:    []int{2, 1},
+			wantTopics: []string{"messages.high", "messages.low"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemory(config.Broker{Topic: "messages", PriorityWeight: tt.weight})
+			defer b.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			if err := b.Send(ctx, tt.send); err != nil {
+				t.Fatal(err)
+			}
+
+			sent := make(map[int]model.Message)
+			for _, m := range tt.send {
+				sent[m.ID] = m
+			}
+
+			offsets := make(map[int64]bool)
+			for i, id := range tt.wantIDs {
+				d, err := b.Recv(ctx)
+				if err != nil {
+					t.Fatalf("recv %d: %v", i, err)
+				}
+				if d.Message.ID != id {
+					t.Errorf("recv %d: id = %d, want %d", i, d.Message.ID, id)
+				}
+				if m := sent[id]; d.Message.Content != m.Content || d.Message.From != m.From || d.Message.To != m.To {
+					t.Errorf("recv %d: message = %+v, want %+v", i, d.Message, m)
+				}
+				if d.Topic != tt.wantTopics[i] {
+					t.Errorf("recv %d: topic = %q, want %q", i, d.Topic, tt.wantTopics[i])
+				}
+				if offsets[d.Offset] {
+					t.Errorf("recv %d: offset %d repeated", i, d.Offset)
+				}
+				offsets[d.Offset] = true
+
+				if err = b.Commit(ctx, d); err != nil {
+					t.Errorf("commit %d: %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryRecv(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(b *Memory, cancel context.CancelFunc)
+		wantErr error
+	}{
+		{name: "closed", prepare: func(b *Memory, _ context.CancelFunc) { b.Close() }, wantErr: errClosed},
+		{name: "closed twice", prepare: func(b *Memory, _ context.CancelFunc) { b.Close(); b.Close() }, wantErr: errClosed},
+		{name: "canceled", prepare: func(_ *Memory, cancel context.CancelFunc) { cancel() }, wantErr: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemory(config.Broker{Topic: "messages", PriorityWeight: 3})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			tt.prepare(b, cancel)
+			if _, err := b.Recv(ctx); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("recv error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMemoryDeadLetter(t *testing.T) {
+	b := NewMemory(config.Broker{Topic: "messages", PriorityWeight: 3})
+	defer b.Close()
+	ctx := context.Background()
+
+	if err := b.Send(ctx, []model.Message{{ID: 1}, {ID: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		d, err := b.Recv(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Message.ID == 2 {
+			if err = b.DeadLetter(ctx, d, errors.New("poison")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	dead := b.DeadLetters()
+	if len(dead) != 1 || dead[0].Message.ID != 2 {
+		t.Fatalf("dead letters = %+v, want message 2", dead)
+	}
+}
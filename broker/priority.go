@@ -0,0 +1,127 @@
+package broker
+
+import (
+	"context"
+	"math/rand/v2"
+	"reflect"
+	"sync"
+
+	"messaggio/model"
+)
+
+// priorities — приоритеты от низкого к высокому, индекс в срезе совпадает с индексом очереди.
+var priorities = []int{model.PriorityLow, model.PriorityNormal, model.PriorityHigh}
+
+// priorityIndex возвращает индекс очереди приоритета, неизвестные значения прижимаются к границам.
+func priorityIndex(priority int) int {
+	return min(max(priority, model.PriorityLow), model.PriorityHigh) - model.PriorityLow
+}
+
+// priorityTopic возвращает топик приоритета. Обычный приоритет остаётся в основном топике,
+// поэтому сообщения, отправленные до появления приоритетов, читаются как обычные.
+func priorityTopic(topic string, priority int) string {
+	switch priorities[priorityIndex(priority)] {
+	case model.PriorityLow:
+		return topic + ".low"
+	case model.PriorityHigh:
+		return topic + ".high"
+	default:
+		return topic
+	}
+}
+
+// prioritySelector выбирает следующее сообщение из очередей приоритетов. Среди очередей,
+// где сообщение уже есть, выбор случайный с весом weight^index: при общей нагрузке
+// высокий приоритет читается чаще, но низкий не простаивает полностью.
+type prioritySelector[T any] struct {
+	mu      sync.Mutex
+	queues  []chan T
+	weights []int
+	pending []*T
+}
+
+func newPrioritySelector[T any](queues []chan T, weight int) *prioritySelector[T] {
+	weights := make([]int, len(queues))
+	for i := range weights {
+		weights[i] = 1
+		for j := 0; j < i; j++ {
+			weights[i] *= weight
+		}
+	}
+
+	return &prioritySelector[T]{
+		queues:  queues,
+		weights: weights,
+		pending: make([]*T, len(queues)),
+	}
+}
+
+func (s *prioritySelector[T]) next(ctx context.Context, closed <-chan struct{}) (T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.fill() {
+		cases := make([]reflect.SelectCase, 0, len(s.queues)+2)
+		for _, q := range s.queues {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(q)})
+		}
+		cases = append(cases,
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(closed)},
+		)
+
+		var zero T
+		chosen, v, _ := reflect.Select(cases)
+		switch chosen {
+		case len(s.queues):
+			return zero, ctx.Err()
+		case len(s.queues) + 1:
+			return zero, errClosed
+		}
+
+		item := v.Interface().(T)
+		s.pending[chosen] = &item
+		s.fill()
+	}
+
+	return s.pick(), nil
+}
+
+// fill без блокировки забирает по одному сообщению из очередей с пустым слотом
+// и сообщает, есть ли хоть одно ожидающее сообщение.
+func (s *prioritySelector[T]) fill() bool {
+	ready := false
+	for i, q := range s.queues {
+		if s.pending[i] == nil {
+			select {
+			case item := <-q:
+				s.pending[i] = &item
+			default:
+			}
+		}
+		ready = ready || s.pending[i] != nil
+	}
+	return ready
+}
+
+func (s *prioritySelector[T]) pick() T {
+	total := 0
+	for i, item := range s.pending {
+		if item != nil {
+			total += s.weights[i]
+		}
+	}
+
+	n := rand.IntN(total)
+	for i, item := range s.pending {
+		if item == nil {
+			continue
+		}
+		if n < s.weights[i] {
+			s.pending[i] = nil
+			return *item
+		}
+		n -= s.weights[i]
+	}
+	panic("unreachable")
+}
@@ -0,0 +1,175 @@
+package callback
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"messaggio/config"
+	"messaggio/model"
+	"messaggio/netguard"
+	"messaggio/prometheus"
+	"messaggio/storage"
+)
+
+const (
+	HeaderDelivery  = "X-Messaggio-Delivery"
+	HeaderTimestamp = "X-Messaggio-Timestamp"
+	HeaderSignature = "X-Messaggio-Signature"
+)
+
+// Payload — тело уведомления. ID уведомления не меняется между повторами,
+// по нему получатель может отбросить дубликаты.
+type Payload struct {
+	ID        int       `json:"id"`
+	MessageID int       `json:"message_id"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Sign возвращает подпись тела уведомления: hex(HMAC-SHA256(secret, timestamp + "." + body)).
+// Получатель проверяет её, пересчитав по заголовку X-Messaggio-Timestamp и телу запроса.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher отправляет уведомления о статусах сообщений на callback_url с повторами
+// и экспоненциальной задержкой. Каждая попытка сохраняется в storage. Запросы на внутренние
+// адреса не выполняются, перенаправления не отслеживаются: ответ 3xx считается отказом.
+type Dispatcher struct {
+	storage    storage.Repository
+	prometheus *prometheus.Prometheus
+	client     *http.Client
+	cfg        config.Callback
+}
+
+func New(s storage.Repository, p *prometheus.Prometheus, cfg config.Callback) *Dispatcher {
+	return &Dispatcher{
+		storage:    s,
+		prometheus: p,
+		client:     netguard.NewClient(cfg.Timeout),
+		cfg:        cfg,
+	}
+}
+
+// Run отправляет уведомления до отмены ctx. Начатые запросы дожидаются ответа.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	work := context.WithoutCancel(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("callback dispatcher stopped")
+			return nil
+		case <-time.After(d.cfg.PollInterval):
+			// Уведомление откладывается на время запроса с запасом, чтобы его не взял другой экземпляр
+			callbacks, err := d.storage.ClaimCallbacks(work, d.cfg.BatchSize, 2*d.cfg.Timeout)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+
+			var wg sync.WaitGroup
+			for _, cb := range callbacks {
+				wg.Add(1)
+				go func(cb model.Callback) {
+					defer wg.Done()
+					d.dispatch(work, cb)
+				}(cb)
+			}
+			wg.Wait()
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, cb model.Callback) {
+	cb.Attempts++
+	attempt := model.CallbackAttempt{
+		CallbackID: cb.ID,
+		MessageID:  cb.MessageID,
+		Attempt:    cb.Attempts,
+	}
+
+	code, err := d.send(ctx, cb)
+	attempt.ResponseCode = code
+
+	switch {
+	case err == nil:
+		cb.State = model.CallbackDelivered.String()
+	case cb.Attempts >= d.cfg.MaxAttempts:
+		cb.State = model.CallbackFailed.String()
+		attempt.Error = err.Error()
+		log.Warnf("callback %d for message %d failed after %d attempts: %s", cb.ID, cb.MessageID, cb.Attempts, err)
+	default:
+		cb.NextAttemptAt = time.Now().Add(d.backoff(cb.Attempts))
+		attempt.Error = err.Error()
+	}
+	attempt.State = cb.State
+
+	if err = d.storage.SaveCallbackAttempt(&cb, &attempt); err != nil {
+		log.Error(err)
+		return
+	}
+
+	d.prometheus.CallbackCounter.WithLabelValues(cb.State).Inc()
+}
+
+// backoff возвращает задержку перед следующей попыткой после attempts неудачных.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.RetryInterval
+	for i := 1; i < attempts && delay < d.cfg.MaxRetryInterval; i++ {
+		delay *= 2
+	}
+	return min(delay, d.cfg.MaxRetryInterval)
+}
+
+// send отправляет уведомление и возвращает код ответа. Доставленным считается только ответ 2xx.
+func (d *Dispatcher) send(ctx context.Context, cb model.Callback) (int, error) {
+	body, err := json.Marshal(Payload{
+		ID:        cb.ID,
+		MessageID: cb.MessageID,
+		Status:    cb.Status,
+		Error:     cb.Error,
+		Timestamp: cb.Timestamp,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cb.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderDelivery, strconv.Itoa(cb.ID))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if d.cfg.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(d.cfg.Secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
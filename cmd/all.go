@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"messaggio/broker"
+	"messaggio/callback"
+	"messaggio/delivery"
+	"messaggio/prometheus"
+	"messaggio/receiver"
+	"messaggio/sender"
+	"messaggio/server"
+	"messaggio/storage"
+	"messaggio/sweeper"
+)
+
+func init() {
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "all",
+		Short: "server, sender and receiver in one process",
+		Long:  "Runs the HTTP server, sender and receiver in one process with shared storage and metrics",
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg := getConfig(cmd)
+
+			log.Trace("all started")
+			defer log.Trace("all stopped")
+
+			l := newLifecycle(cmd.Context(), cfg.ShutdownTimeout)
+
+			store, err := storage.Open(cmd.Context(), cfg.DB)
+			if err != nil {
+				log.Fatalf("storage.Open: %s", err)
+			}
+			l.OnStop("storage", store.Close)
+
+			b, err := broker.New(cfg.Kafka)
+			if err != nil {
+				log.Fatalf("broker.New: %s", err)
+			}
+			l.OnStop("broker", b.Close)
+
+			// Метрики общие: /metrics сервера отдаёт и счётчики sender/receiver
+			p := prometheus.New(store)
+
+			l.Go("http server", server.New(cfg.Server, cfg.Delivery, store, p).Run)
+			d, err := delivery.New(cfg.Delivery)
+			if err != nil {
+				log.Fatalf("delivery.New: %s", err)
+			}
+			l.OnStop("delivery", d.Close)
+
+			l.Go("receiver", receiver.New(b, d, store, p, cfg.Kafka).Run)
+			l.Go("sender", sender.New(b, store, p, cfg.Kafka).Run)
+			l.Go("callback dispatcher", callback.New(store, p, cfg.Callback).Run)
+			l.Go("sweeper", sweeper.New(store, p, cfg.Kafka).Run)
+
+			if err = l.Wait(); err != nil {
+				log.Fatal(err)
+			}
+		},
+	})
+}
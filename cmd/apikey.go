@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"messaggio/auth"
+	"messaggio/model"
+	"messaggio/storage"
+)
+
+func init() {
+	apikeyCmd := &cobra.Command{
+		Use:   "apikey",
+		Short: "apikey",
+		Long:  "API keys",
+	}
+
+	createCmd := &cobra.Command{
+		Use:   "create NAME",
+		Short: "Create an API key and print it once",
+		Long: "Create an API key. Keys with the same name share messages, so a key can be rotated\n" +
+			"by creating a new one with the same name and revoking the old one.",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			scopes, _ := cmd.Flags().GetStringSlice("scope")
+			quota, _ := cmd.Flags().GetInt("daily-quota")
+			if quota < 0 {
+				log.Fatalf("invalid daily quota %d", quota)
+			}
+			for _, scope := range scopes {
+				if !slices.Contains([]string{model.ScopeMessages, model.ScopeAdmin}, scope) {
+					log.Fatalf("unknown scope %q, must be %s or %s", scope, model.ScopeMessages, model.ScopeAdmin)
+				}
+			}
+
+			key, hash, err := auth.GenerateKey()
+			if err != nil {
+				log.Fatalf("auth.GenerateKey: %s", err)
+			}
+
+			store := openStorage(cmd)
+			defer store.Close()
+
+			apiKey := model.APIKey{
+				Name:       args[0],
+				KeyHash:    hash,
+				Scopes:     scopes,
+				DailyQuota: quota,
+			}
+			if err = store.CreateAPIKey(&apiKey); err != nil {
+				log.Fatalf("storage.CreateAPIKey: %s", err)
+			}
+
+			log.Infof("api key %d %q created with scopes %s", apiKey.ID, apiKey.Name, strings.Join(scopes, ","))
+			cmd.Println(key)
+		},
+	}
+	createCmd.Flags().StringSlice("scope", []string{model.ScopeMessages}, "key scopes: messages, admin")
+	createCmd.Flags().Int("daily-quota", 0, "messages per UTC day for this key, 0 for the server default")
+	apikeyCmd.AddCommand(createCmd)
+
+	apikeyCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List API keys",
+		Run: func(cmd *cobra.Command, args []string) {
+			store := openStorage(cmd)
+			defer store.Close()
+
+			keys, err := store.SelectAPIKeys()
+			if err != nil {
+				log.Fatalf("storage.SelectAPIKeys: %s", err)
+			}
+
+			for _, k := range keys {
+				state := "active"
+				if k.RevokedAt != nil {
+					state = "revoked at " + k.RevokedAt.Format("2006-01-02 15:04:05")
+				}
+				quota := "default quota"
+				if k.DailyQuota > 0 {
+					quota = "quota " + strconv.Itoa(k.DailyQuota)
+				}
+				cmd.Printf("%d\t%s\t%s\t%s\tcreated at %s\t%s\n", k.ID, k.Name, strings.Join(k.Scopes, ","), quota, k.CreatedAt.Format("2006-01-02 15:04:05"), state)
+			}
+		},
+	})
+
+	apikeyCmd.AddCommand(&cobra.Command{
+		Use:   "revoke ID",
+		Short: "Revoke an API key",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			id, err := strconv.Atoi(args[0])
+			if err != nil {
+				log.Fatalf("invalid id %q", args[0])
+			}
+
+			store := openStorage(cmd)
+			defer store.Close()
+
+			if err = store.RevokeAPIKey(id); err != nil {
+				log.Fatalf("storage.RevokeAPIKey: %s", err)
+			}
+			log.Infof("api key %d revoked", id)
+		},
+	})
+
+	rootCmd.AddCommand(apikeyCmd)
+}
+
+// openStorage подключается к базе с применением миграций: таблица api_keys может ещё не существовать.
+func openStorage(cmd *cobra.Command) storage.Repository {
+	cfg := getConfig(cmd)
+
+	store, err := storage.Open(cmd.Context(), cfg.DB)
+	if err != nil {
+		log.Fatalf("storage.Open: %s", err)
+	}
+	return store
+}
@@ -1,16 +1,20 @@
 package cmd
 
 import (
-	"os"
-	"os/signal"
-	"syscall"
+	"context"
+	"net/http"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"messaggio/broker"
+	"messaggio/callback"
+	"messaggio/delivery"
+	"messaggio/prometheus"
 	"messaggio/receiver"
 	"messaggio/sender"
 	"messaggio/storage"
+	"messaggio/sweeper"
 )
 
 func init() {
@@ -19,40 +23,51 @@ func init() {
 		Short: "broker",
 		Long:  "broker",
 		Run: func(cmd *cobra.Command, args []string) {
-			cfg := getConfig()
+			cfg := getConfig(cmd)
 
 			log.Trace("broker started")
 			defer log.Trace("broker stopped")
 
-			b, err := broker.New(cfg.Kafka)
+			l := newLifecycle(cmd.Context(), cfg.ShutdownTimeout)
+
+			store, err := storage.Open(cmd.Context(), cfg.DB)
 			if err != nil {
-				log.Fatalf("kafka.New: %s", err)
+				log.Fatalf("storage.Open: %s", err)
 			}
-			defer b.Close()
+			l.OnStop("storage", store.Close)
 
-			store, err := storage.New(cmd.Context(), cfg.DB)
+			// Закрывается раньше хранилища: writer дописывает буфер, reader фиксирует offset'ы
+			b, err := broker.New(cfg.Kafka)
 			if err != nil {
-				log.Fatalf("storage.New: %s", err)
+				log.Fatalf("broker.New: %s", err)
 			}
-			defer store.Close()
+			l.OnStop("broker", b.Close)
+
+			p := prometheus.New(store)
 
-			log.Trace("receiver started")
-			r := receiver.New(b, store, cfg.Kafka)
-			r.Start(cmd.Context())
+			metrics := &http.Server{
+				Addr:    cfg.Kafka.MetricsHttp,
+				Handler: promhttp.Handler(),
+			}
+			l.Go("metrics server", func(ctx context.Context) error {
+				log.Infof("metrics server starting on %s", cfg.Kafka.MetricsHttp)
+				return serveHTTP(ctx, metrics, cfg.Server.ShutdownTimeout)
+			})
 
-			log.Trace("sender started")
-			s := sender.New(b, store, cfg.Kafka)
-			s.Start(cmd.Context())
+			d, err := delivery.New(cfg.Delivery)
+			if err != nil {
+				log.Fatalf("delivery.New: %s", err)
+			}
+			l.OnStop("delivery", d.Close)
 
-			c := make(chan os.Signal, 1)
-			signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-			<-c
-			log.Trace("signal received")
+			l.Go("receiver", receiver.New(b, d, store, p, cfg.Kafka).Run)
+			l.Go("sender", sender.New(b, store, p, cfg.Kafka).Run)
+			l.Go("callback dispatcher", callback.New(store, p, cfg.Callback).Run)
+			l.Go("sweeper", sweeper.New(store, p, cfg.Kafka).Run)
 
-			r.Close()
-			log.Trace("receiver stopped")
-			s.Close()
-			log.Trace("sender stopped")
+			if err = l.Wait(); err != nil {
+				log.Fatal(err)
+			}
 		},
 	})
 }
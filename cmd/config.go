@@ -1,44 +1,256 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"runtime"
+	"strconv"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 	"messaggio/config"
 )
 
-type Config struct {
-	DB     config.DataBase
-	Server config.Server
-	Kafka  config.Broker
+// setting связывает поле конфигурации с переменной окружения и флагом.
+// Приоритет: значения по умолчанию < файл конфигурации < переменные окружения < флаги.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	value interface{}
 }
 
-func getConfig() *Config {
-	log.SetFormatter(&log.TextFormatter{
-		TimestampFormat: "2006-01-02 15:04:05",
-		FullTimestamp:   true,
-		CallerPrettyfier: func(frame *runtime.Frame) (function string, file string) {
-			return "", fmt.Sprintf("%s:%d", frame.Function, frame.Line)
+func settings(cfg *config.Config) []setting {
+	return []setting{
+		{"log-level", "LOG_LEVEL", "log level: trace, debug, info, warn, error", &cfg.Log.Level},
+		{"log-format", "LOG_FORMAT", "log format: text or json", &cfg.Log.Format},
+
+		{"db-addr", "DATABASE_ADDRESS", "PostgreSQL URL or memory://", &cfg.DB.Addr},
+		{"db-pool-size", "DATABASE_POOL_SIZE", "PostgreSQL connection pool size, 0 for driver default", &cfg.DB.PoolSize},
+		{"instance-id", "INSTANCE_ID", "instance ID written to message history", &cfg.DB.Instance},
+
+		{"server-http", "SERVER_HTTP", "HTTP listen address", &cfg.Server.Http},
+		{"prometheus-addr", "PROMETHEUS_ADDRESS", "Prometheus address", &cfg.Server.PrometheusAddr},
+		{"server-read-timeout", "SERVER_READ_TIMEOUT", "HTTP read timeout", &cfg.Server.ReadTimeout},
+		{"server-write-timeout", "SERVER_WRITE_TIMEOUT", "HTTP write timeout, 0 to keep streams open", &cfg.Server.WriteTimeout},
+		{"server-idle-timeout", "SERVER_IDLE_TIMEOUT", "HTTP keep-alive idle timeout", &cfg.Server.IdleTimeout},
+		{"server-shutdown-timeout", "SERVER_SHUTDOWN_TIMEOUT", "time to finish active HTTP requests on shutdown", &cfg.Server.ShutdownTimeout},
+		{"server-default-limit", "SERVER_DEFAULT_LIMIT", "default page size of GET /api/messages", &cfg.Server.DefaultLimit},
+		{"server-max-limit", "SERVER_MAX_LIMIT", "max page size of GET /api/messages", &cfg.Server.MaxLimit},
+		{"idempotency-key-retention", "IDEMPOTENCY_KEY_RETENTION", "how long idempotency keys are kept", &cfg.Server.IdempotencyRetention},
+		{"max-body-size", "SERVER_MAX_BODY_SIZE", "max body size of POST /api/messages in bytes", &cfg.Server.MaxBodySize},
+		{"max-batch-body-size", "SERVER_MAX_BATCH_BODY_SIZE", "max body size of POST /api/messages/batch in bytes", &cfg.Server.MaxBatchBodySize},
+		{"max-content-length", "MAX_CONTENT_LENGTH", "max message content length in characters", &cfg.Server.MaxContentLength},
+		{"server-auth", "SERVER_AUTH", "require an API key or JWT on every API request", &cfg.Server.Auth},
+		{"admin-api-key", "ADMIN_API_KEY", "static API key with the admin scope, empty to disable", &cfg.Server.AdminKey},
+		{"jwt-secret", "JWT_SECRET", "HS256 key for JWT bearer tokens, empty to accept API keys only", &cfg.Server.JWTSecret},
+		{"ticket-secret", "TICKET_SECRET", "HMAC-SHA256 key for stream tickets, empty for a random key per process", &cfg.Server.TicketSecret},
+		{"ticket-ttl", "TICKET_TTL", "lifetime of a stream ticket", &cfg.Server.TicketTTL},
+		{"allowed-origins", "ALLOWED_ORIGINS", "comma-separated origins allowed to open a WebSocket, * for any", &cfg.Server.AllowedOrigins},
+		{"rate-limit", "RATE_LIMIT", "API requests per second per key or IP, 0 to disable", &cfg.Server.RateLimit},
+		{"rate-burst", "RATE_BURST", "API requests allowed in a burst above rate-limit", &cfg.Server.RateBurst},
+		{"ip-rate-limit", "IP_RATE_LIMIT", "API requests per second per IP before authentication, 0 to disable", &cfg.Server.IPRateLimit},
+		{"ip-rate-burst", "IP_RATE_BURST", "API requests per IP allowed in a burst above ip-rate-limit", &cfg.Server.IPRateBurst},
+		{"daily-quota", "DAILY_QUOTA", "messages per UTC day per owner unless the key sets its own, 0 to disable", &cfg.Server.DailyQuota},
+
+		{"broker-backend", "BROKER_BACKEND", "broker backend: kafka or memory", &cfg.Kafka.Backend},
+		{"broker-metrics-http", "BROKER_METRICS_HTTP", "broker metrics listen address", &cfg.Kafka.MetricsHttp},
+		{"kafka-addr", "KAFKA_ADDRESS", "Kafka address", &cfg.Kafka.KafkaAddr},
+		{"kafka-topic", "KAFKA_TOPIC", "Kafka topic", &cfg.Kafka.Topic},
+		{"kafka-group-id", "KAFKA_GROUP_ID", "Kafka consumer group", &cfg.Kafka.GroupID},
+		{"kafka-start-offset", "KAFKA_START_OFFSET", "start offset for a new consumer group: first or last", &cfg.Kafka.StartOffset},
+		{"kafka-partitions", "KAFKA_PARTITIONS", "partitions of a created topic", &cfg.Kafka.Partitions},
+		{"kafka-batch-size", "SENDER_BATCH_SIZE", "messages claimed and published per batch", &cfg.Kafka.BatchSize},
+		{"kafka-batch-timeout", "KAFKA_BATCH_TIMEOUT", "max time the Kafka writer waits to fill a batch", &cfg.Kafka.BatchTimeout},
+		{"kafka-dead-letter-topic", "KAFKA_DEAD_LETTER_TOPIC", "Kafka dead-letter topic", &cfg.Kafka.DeadLetterTopic},
+		{"kafka-priority-weight", "KAFKA_PRIORITY_WEIGHT", "how many times more often each higher priority topic is read", &cfg.Kafka.PriorityWeight},
+		{"max-attempts", "MAX_ATTEMPTS", "processing attempts before a message goes to error", &cfg.Kafka.MaxAttempts},
+		{"sender-interval", "SENDER_INTERVAL", "sender poll interval after a partial or empty batch", &cfg.Kafka.SendInterval},
+		{"receiver-interval", "RECEIVER_INTERVAL", "receiver pause after a broker read error", &cfg.Kafka.RecvInterval},
+		{"expire-interval", "EXPIRE_INTERVAL", "how often messages past their TTL are expired", &cfg.Kafka.ExpireInterval},
+
+		{"delivery-default-channel", "DELIVERY_DEFAULT_CHANNEL", "channel for addresses without a scheme: sink or smtp", &cfg.Delivery.DefaultChannel},
+		{"delivery-timeout", "DELIVERY_TIMEOUT", "timeout of a single delivery attempt", &cfg.Delivery.Timeout},
+		{"delivery-sink-file", "DELIVERY_SINK_FILE", "file the sink channel appends to, empty for stdout", &cfg.Delivery.SinkFile},
+		{"delivery-webhook-allow-private", "DELIVERY_WEBHOOK_ALLOW_PRIVATE", "allow webhooks to localhost and private networks", &cfg.Delivery.WebhookAllowPrivate},
+		{"smtp-addr", "SMTP_ADDRESS", "SMTP server host:port, empty to disable email delivery", &cfg.Delivery.SMTPAddr},
+		{"smtp-username", "SMTP_USERNAME", "SMTP username", &cfg.Delivery.SMTPUsername},
+		{"smtp-password", "SMTP_PASSWORD", "SMTP password", &cfg.Delivery.SMTPPassword},
+		{"smtp-from", "SMTP_FROM", "SMTP sender address", &cfg.Delivery.SMTPFrom},
+
+		{"callback-secret", "CALLBACK_SECRET", "HMAC-SHA256 key for callback signatures, empty to send unsigned", &cfg.Callback.Secret},
+		{"callback-timeout", "CALLBACK_TIMEOUT", "timeout of a single callback request", &cfg.Callback.Timeout},
+		{"callback-poll-interval", "CALLBACK_POLL_INTERVAL", "callback dispatcher poll interval", &cfg.Callback.PollInterval},
+		{"callback-batch-size", "CALLBACK_BATCH_SIZE", "callbacks sent concurrently per poll", &cfg.Callback.BatchSize},
+		{"callback-max-attempts", "CALLBACK_MAX_ATTEMPTS", "callback attempts before giving up", &cfg.Callback.MaxAttempts},
+		{"callback-retry-interval", "CALLBACK_RETRY_INTERVAL", "delay before the first callback retry, doubled on each attempt", &cfg.Callback.RetryInterval},
+		{"callback-max-retry-interval", "CALLBACK_MAX_RETRY_INTERVAL", "max delay between callback retries", &cfg.Callback.MaxRetryInterval},
+
+		{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "time to stop all components gracefully after a signal", &cfg.ShutdownTimeout},
+	}
+}
+
+func init() {
+	flags := rootCmd.PersistentFlags()
+	flags.String("config", "", "path to a YAML config file (env CONFIG_FILE)")
+
+	defaults := config.Default()
+	for _, s := range settings(&defaults) {
+		flags.String(s.flag, "", fmt.Sprintf("%s (env %s, default %q)", s.usage, s.env, s.get()))
+	}
+
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "config",
+		Long:  "Configuration",
+	}
+
+	configCmd.AddCommand(&cobra.Command{
+		Use:   "print",
+		Short: "Print the effective config with secrets masked",
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				log.Fatalf("invalid config:\n%s", err)
+			}
+
+			data, err := yaml.Marshal(cfg.Masked())
+			if err != nil {
+				log.Fatalf("yaml.Marshal: %s", err)
+			}
+			_, _ = fmt.Fprint(cmd.OutOrStdout(), string(data))
+
+			if err = cfg.Validate(); err != nil {
+				log.Warnf("invalid config:\n%s", err)
+			}
 		},
 	})
 
-	log.SetReportCaller(true)
+	rootCmd.AddCommand(configCmd)
+}
 
-	log.SetLevel(log.TraceLevel)
+func (s setting) get() string {
+	switch v := s.value.(type) {
+	case *string:
+		return *v
+	case *int:
+		return strconv.Itoa(*v)
+	case *bool:
+		return strconv.FormatBool(*v)
+	case *time.Duration:
+		return v.String()
+	default:
+		panic(fmt.Sprintf("unsupported setting type %T", s.value))
+	}
+}
 
-	return &Config{
-		Server: config.Server{
-			Http:           os.Getenv("SERVER_HTTP"),
-			PrometheusAddr: os.Getenv("PROMETHEUS_ADDRESS"),
-		},
-		DB: config.DataBase{
-			Addr: os.Getenv("DATABASE_ADDRESS"),
-		},
-		Kafka: config.Broker{
-			KafkaAddr:  os.Getenv("KAFKA_ADDRESS"),
-			ServerAddr: os.Getenv("SERVER_ADDRESS"),
-		},
+func (s setting) set(raw string) error {
+	switch v := s.value.(type) {
+	case *string:
+		*v = raw
+	case *int:
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		*v = value
+	case *bool:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		*v = value
+	case *time.Duration:
+		value, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		*v = value
+	default:
+		panic(fmt.Sprintf("unsupported setting type %T", s.value))
+	}
+	return nil
+}
+
+// loadConfig собирает конфигурацию из значений по умолчанию, файла, окружения и флагов без валидации.
+func loadConfig(cmd *cobra.Command) (*config.Config, error) {
+	cfg := config.Default()
+
+	path := os.Getenv("CONFIG_FILE")
+	if f := cmd.Flags().Lookup("config"); f != nil && f.Changed {
+		path = f.Value.String()
+	}
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		decoder := yaml.NewDecoder(file)
+		decoder.KnownFields(true)
+		if err = decoder.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	var errs []error
+	for _, s := range settings(&cfg) {
+		if value := os.Getenv(s.env); value != "" {
+			if err := s.set(value); err != nil {
+				errs = append(errs, fmt.Errorf("env %s: %w", s.env, err))
+			}
+		}
+	}
+
+	for _, s := range settings(&cfg) {
+		if f := cmd.Flags().Lookup(s.flag); f != nil && f.Changed {
+			if err := s.set(f.Value.String()); err != nil {
+				errs = append(errs, fmt.Errorf("flag --%s: %w", s.flag, err))
+			}
+		}
+	}
+
+	return &cfg, errors.Join(errs...)
+}
+
+func getConfig(cmd *cobra.Command) *config.Config {
+	cfg, err := loadConfig(cmd)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		log.Fatalf("invalid config:\n%s", err)
+	}
+
+	setupLog(cfg.Log)
+
+	return cfg
+}
+
+func setupLog(cfg config.Log) {
+	callerPrettyfier := func(frame *runtime.Frame) (function string, file string) {
+		return "", fmt.Sprintf("%s:%d", frame.Function, frame.Line)
+	}
+
+	if cfg.Format == "json" {
+		log.SetFormatter(&log.JSONFormatter{
+			TimestampFormat:  "2006-01-02 15:04:05",
+			CallerPrettyfier: callerPrettyfier,
+		})
+	} else {
+		log.SetFormatter(&log.TextFormatter{
+			TimestampFormat:  "2006-01-02 15:04:05",
+			FullTimestamp:    true,
+			CallerPrettyfier: callerPrettyfier,
+		})
 	}
+
+	log.SetReportCaller(true)
+
+	level, _ := log.ParseLevel(cfg.Level)
+	log.SetLevel(level)
 }
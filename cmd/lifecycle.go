@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// lifecycle запускает компоненты процесса одной группой по образцу errgroup.
+// SIGINT/SIGTERM или завершение любого компонента отменяет общий контекст,
+// после чего остальные компоненты получают timeout на корректную остановку.
+type lifecycle struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+
+	wg    sync.WaitGroup
+	once  sync.Once
+	err   error
+	stops []stop
+}
+
+type stop struct {
+	name string
+	fn   func()
+}
+
+func newLifecycle(ctx context.Context, timeout time.Duration) *lifecycle {
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	return &lifecycle{
+		ctx:     ctx,
+		cancel:  cancel,
+		timeout: timeout,
+	}
+}
+
+// Go запускает компонент. fn должен вернуться после отмены ctx.
+func (l *lifecycle) Go(name string, fn func(ctx context.Context) error) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		defer l.cancel()
+
+		log.Tracef("%s started", name)
+		if err := fn(l.ctx); err != nil {
+			log.Errorf("%s: %s", name, err)
+			l.once.Do(func() {
+				l.err = fmt.Errorf("%s: %w", name, err)
+			})
+		}
+		log.Tracef("%s stopped", name)
+	}()
+}
+
+// OnStop регистрирует освобождение ресурса. Вызывается после остановки всех
+// компонентов в порядке, обратном регистрации.
+func (l *lifecycle) OnStop(name string, fn func()) {
+	l.stops = append(l.stops, stop{name: name, fn: fn})
+}
+
+// Wait блокируется до сигнала или ошибки компонента, затем останавливает всё
+// и возвращает первую ошибку.
+func (l *lifecycle) Wait() error {
+	<-l.ctx.Done()
+	log.Trace("shutting down")
+
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(l.timeout):
+		log.Errorf("components did not stop within %s", l.timeout)
+	}
+
+	for i := len(l.stops) - 1; i >= 0; i-- {
+		l.stops[i].fn()
+		log.Tracef("%s closed", l.stops[i].name)
+	}
+
+	l.cancel()
+	return l.err
+}
+
+// serveHTTP обслуживает srv до отмены ctx и даёт активным запросам timeout на завершение.
+func serveHTTP(ctx context.Context, srv *http.Server, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		_ = srv.Close()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"messaggio/storage"
+)
+
+func init() {
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "migrate",
+		Long:  "Database schema migrations",
+	}
+
+	migrateCmd.AddCommand(&cobra.Command{
+		Use:   "up",
+		Short: "Apply all pending migrations",
+		Run: func(cmd *cobra.Command, args []string) {
+			store := connectStorage(cmd)
+			defer store.Close()
+
+			migrations, err := store.MigrateUp(cmd.Context())
+			if err != nil {
+				log.Fatalf("storage.MigrateUp: %s", err)
+			}
+
+			for _, m := range migrations {
+				log.Infof("migration %d_%s applied", m.Version, m.Name)
+			}
+			log.Infof("%d migrations applied", len(migrations))
+		},
+	})
+
+	migrateCmd.AddCommand(&cobra.Command{
+		Use:   "down [steps]",
+		Short: "Roll back the last applied migrations (1 by default)",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			steps := 1
+			if len(args) > 0 {
+				var err error
+				if steps, err = strconv.Atoi(args[0]); err != nil || steps <= 0 {
+					log.Fatalf("invalid steps %q", args[0])
+				}
+			}
+
+			store := connectStorage(cmd)
+			defer store.Close()
+
+			migrations, err := store.MigrateDown(cmd.Context(), steps)
+			if err != nil {
+				log.Fatalf("storage.MigrateDown: %s", err)
+			}
+
+			for _, m := range migrations {
+				log.Infof("migration %d_%s rolled back", m.Version, m.Name)
+			}
+			log.Infof("%d migrations rolled back", len(migrations))
+		},
+	})
+
+	migrateCmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show applied and pending migrations",
+		Run: func(cmd *cobra.Command, args []string) {
+			store := connectStorage(cmd)
+			defer store.Close()
+
+			statuses, err := store.MigrationStatus(cmd.Context())
+			if err != nil {
+				log.Fatalf("storage.MigrationStatus: %s", err)
+			}
+
+			for _, s := range statuses {
+				if s.AppliedAt == nil {
+					cmd.Printf("%04d_%s\tpending\n", s.Version, s.Name)
+					continue
+				}
+				cmd.Printf("%04d_%s\tapplied at %s\n", s.Version, s.Name, s.AppliedAt.Format("2006-01-02 15:04:05"))
+			}
+		},
+	})
+
+	rootCmd.AddCommand(migrateCmd)
+}
+
+func connectStorage(cmd *cobra.Command) *storage.Storage {
+	cfg := getConfig(cmd)
+
+	store, err := storage.Connect(cmd.Context(), cfg.DB)
+	if err != nil {
+		log.Fatalf("storage.Connect: %s", err)
+	}
+	return store
+}
@@ -1,10 +1,6 @@
 package cmd
 
 import (
-	"os"
-	"os/signal"
-	"syscall"
-
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"messaggio/prometheus"
@@ -18,26 +14,26 @@ func init() {
 		Short: "server",
 		Long:  "server",
 		Run: func(cmd *cobra.Command, args []string) {
-			cfg := getConfig()
+			cfg := getConfig(cmd)
 
 			log.Trace("server started")
 			defer log.Trace("server stopped")
 
-			store, err := storage.New(cmd.Context(), cfg.DB)
+			l := newLifecycle(cmd.Context(), cfg.ShutdownTimeout)
+
+			store, err := storage.Open(cmd.Context(), cfg.DB)
 			if err != nil {
-				log.Fatalf("storage.New: %s", err)
+				log.Fatalf("storage.Open: %s", err)
 			}
-			defer store.Close()
+			l.OnStop("storage", store.Close)
 
-			s := server.New(cfg.Server, store, prometheus.New())
-			defer s.Close(cmd.Context())
-			s.Start()
+			s := server.New(cfg.Server, cfg.Delivery, store, prometheus.New(store))
+			l.Go("http server", s.Run)
 
 			// Ожидание завершения работы
-			c := make(chan os.Signal, 1)
-			signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-			<-c
-			log.Trace("signal received")
+			if err = l.Wait(); err != nil {
+				log.Fatal(err)
+			}
 		},
 	})
 }
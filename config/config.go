@@ -1,15 +1,289 @@
 package config
 
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type Config struct {
+	Log    Log      `yaml:"log"`
+	DB     DataBase `yaml:"db"`
+	Server Server   `yaml:"server"`
+	Kafka  Broker   `yaml:"kafka"`
+
+	Delivery Delivery `yaml:"delivery"`
+	Callback Callback `yaml:"callback"`
+
+	// ShutdownTimeout ограничивает время корректной остановки всех компонентов после сигнала.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type Log struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
 type Broker struct {
-	KafkaAddr  string
-	ServerAddr string
+	Backend     string `yaml:"backend"`
+	KafkaAddr   string `yaml:"addr"`
+	MetricsHttp string `yaml:"metrics_http"`
+	Topic       string `yaml:"topic"`
+	GroupID     string `yaml:"group_id"`
+	StartOffset string `yaml:"start_offset"`
+	Partitions  int    `yaml:"partitions"`
+
+	BatchSize    int           `yaml:"batch_size"`
+	BatchTimeout time.Duration `yaml:"batch_timeout"`
+	SendInterval time.Duration `yaml:"send_interval"`
+	RecvInterval time.Duration `yaml:"recv_interval"`
+
+	ExpireInterval time.Duration `yaml:"expire_interval"`
+
+	DeadLetterTopic string `yaml:"dead_letter_topic"`
+	MaxAttempts     int    `yaml:"max_attempts"`
+
+	// PriorityWeight — во сколько раз чаще читается каждый следующий приоритет при общей нагрузке
+	PriorityWeight int `yaml:"priority_weight"`
+}
+
+// Delivery настраивает каналы доставки сообщений получателям.
+type Delivery struct {
+	DefaultChannel string        `yaml:"default_channel"`
+	Timeout        time.Duration `yaml:"timeout"`
+	SinkFile       string        `yaml:"sink_file"`
+
+	// WebhookAllowPrivate разрешает webhook на localhost и адреса внутренних сетей,
+	// если получатели работают рядом с сервисом.
+	WebhookAllowPrivate bool `yaml:"webhook_allow_private"`
+
+	SMTPAddr     string `yaml:"smtp_addr"`
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password"`
+	SMTPFrom     string `yaml:"smtp_from"`
+}
+
+// Callback настраивает уведомления клиентов о статусах сообщений на callback_url.
+type Callback struct {
+	Secret       string        `yaml:"secret"`
+	Timeout      time.Duration `yaml:"timeout"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+	BatchSize    int           `yaml:"batch_size"`
+	MaxAttempts  int           `yaml:"max_attempts"`
+
+	// Задержка перед повтором удваивается с каждой попыткой от RetryInterval до MaxRetryInterval
+	RetryInterval    time.Duration `yaml:"retry_interval"`
+	MaxRetryInterval time.Duration `yaml:"max_retry_interval"`
 }
 
 type DataBase struct {
-	Addr string
+	Addr     string `yaml:"addr"`
+	Instance string `yaml:"instance"`
+	PoolSize int    `yaml:"pool_size"`
 }
 
 type Server struct {
-	Http           string
-	PrometheusAddr string
+	Http           string `yaml:"http"`
+	PrometheusAddr string `yaml:"prometheus_addr"`
+
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+
+	// ShutdownTimeout — сколько ждать завершения активных запросов, прежде чем закрыть соединения.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	DefaultLimit int `yaml:"default_limit"`
+	MaxLimit     int `yaml:"max_limit"`
+
+	IdempotencyRetention time.Duration `yaml:"idempotency_retention"`
+
+	// Ограничения запроса: размер тела в байтах и длина content в символах
+	MaxBodySize      int `yaml:"max_body_size"`
+	MaxBatchBodySize int `yaml:"max_batch_body_size"`
+	MaxContentLength int `yaml:"max_content_length"`
+
+	// Auth включает проверку API-ключей и JWT. Без неё любой запрос получает права admin.
+	Auth bool `yaml:"auth"`
+	// AdminKey — статический ключ с областью admin, например для сбора метрик Prometheus
+	AdminKey string `yaml:"admin_key"`
+	// JWTSecret — ключ проверки подписи HS256 bearer-токенов, пустой — JWT не принимаются
+	JWTSecret string `yaml:"jwt_secret"`
+	// TicketSecret — ключ подписи билетов потоков событий, пустой — случайный ключ процесса.
+	// При нескольких экземплярах server ключ должен быть общим, иначе билет примет только выдавший его.
+	TicketSecret string        `yaml:"ticket_secret"`
+	TicketTTL    time.Duration `yaml:"ticket_ttl"`
+	// AllowedOrigins — Origin через запятую, с которых разрешён WebSocket, * — с любых.
+	// Запросы того же хоста и запросы без Origin разрешены всегда.
+	AllowedOrigins string `yaml:"allowed_origins"`
+
+	// RateLimit — запросов в секунду на API-ключ (без аутентификации — на IP), 0 — без ограничения
+	RateLimit int `yaml:"rate_limit"`
+	RateBurst int `yaml:"rate_burst"`
+	// IPRateLimit — запросов в секунду с одного IP до проверки ключа, 0 — без ограничения
+	IPRateLimit int `yaml:"ip_rate_limit"`
+	IPRateBurst int `yaml:"ip_rate_burst"`
+	// DailyQuota — сообщений в сутки (UTC) на владельца, если у ключа не задана своя квота, 0 — без квоты
+	DailyQuota int `yaml:"daily_quota"`
+}
+
+func Default() Config {
+	return Config{
+		Log: Log{
+			Level:  "trace",
+			Format: "text",
+		},
+		DB: DataBase{
+			Instance: hostname(),
+		},
+		Server: Server{
+			Http:                 ":8080",
+			ReadTimeout:          10 * time.Second,
+			IdleTimeout:          time.Minute,
+			ShutdownTimeout:      10 * time.Second,
+			DefaultLimit:         50,
+			MaxLimit:             1000,
+			IdempotencyRetention: 24 * time.Hour,
+			MaxBodySize:          1 << 20,
+			MaxBatchBodySize:     32 << 20,
+			MaxContentLength:     4096,
+			Auth:                 true,
+			TicketTTL:            time.Minute,
+			RateLimit:            50,
+			RateBurst:            100,
+			IPRateLimit:          100,
+			IPRateBurst:          200,
+		},
+		Kafka: Broker{
+			Backend:         "kafka",
+			MetricsHttp:     ":8081",
+			Topic:           "messaggio",
+			GroupID:         "messaggio",
+			StartOffset:     "first",
+			Partitions:      1,
+			BatchSize:       100,
+			BatchTimeout:    time.Second,
+			SendInterval:    time.Second,
+			RecvInterval:    100 * time.Millisecond,
+			ExpireInterval:  10 * time.Second,
+			DeadLetterTopic: "messaggio.dlq",
+			MaxAttempts:     3,
+			PriorityWeight:  3,
+		},
+		Delivery: Delivery{
+			DefaultChannel: "sink",
+			Timeout:        10 * time.Second,
+		},
+		Callback: Callback{
+			Timeout:          10 * time.Second,
+			PollInterval:     time.Second,
+			BatchSize:        20,
+			MaxAttempts:      10,
+			RetryInterval:    10 * time.Second,
+			MaxRetryInterval: time.Hour,
+		},
+		ShutdownTimeout: 30 * time.Second,
+	}
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
+
+// Validate проверяет итоговую конфигурацию и возвращает все найденные ошибки сразу.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	_, err := log.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level: unknown level %q", c.Log.Level)
+	check(slices.Contains([]string{"text", "json"}, c.Log.Format), "log.format: must be text or json, got %q", c.Log.Format)
+
+	check(c.DB.Addr != "", "db.addr: required")
+	check(c.DB.PoolSize >= 0, "db.pool_size: must not be negative")
+
+	check(c.Server.ReadTimeout >= 0, "server.read_timeout: must not be negative")
+	check(c.Server.WriteTimeout >= 0, "server.write_timeout: must not be negative")
+	check(c.Server.IdleTimeout >= 0, "server.idle_timeout: must not be negative")
+	check(c.Server.ShutdownTimeout > 0 && c.Server.ShutdownTimeout <= c.ShutdownTimeout, "server.shutdown_timeout: must be positive and not exceed shutdown_timeout")
+	check(c.Server.MaxLimit > 0, "server.max_limit: must be positive")
+	check(c.Server.DefaultLimit > 0 && c.Server.DefaultLimit <= c.Server.MaxLimit, "server.default_limit: must be between 1 and server.max_limit")
+	check(c.Server.IdempotencyRetention > 0, "server.idempotency_retention: must be positive")
+	check(c.Server.AdminKey == "" || len(c.Server.AdminKey) >= 16, "server.admin_key: must be at least 16 characters")
+	check(c.Server.MaxBodySize > 0, "server.max_body_size: must be positive")
+	check(c.Server.MaxBatchBodySize > 0, "server.max_batch_body_size: must be positive")
+	check(c.Server.MaxContentLength > 0, "server.max_content_length: must be positive")
+	check(c.Server.RateLimit >= 0, "server.rate_limit: must not be negative")
+	check(c.Server.RateLimit == 0 || c.Server.RateBurst > 0, "server.rate_burst: must be positive with server.rate_limit")
+	check(c.Server.IPRateLimit >= 0, "server.ip_rate_limit: must not be negative")
+	check(c.Server.IPRateLimit == 0 || c.Server.IPRateBurst > 0, "server.ip_rate_burst: must be positive with server.ip_rate_limit")
+	check(c.Server.DailyQuota >= 0, "server.daily_quota: must not be negative")
+	check(c.Server.JWTSecret == "" || len(c.Server.JWTSecret) >= 32, "server.jwt_secret: must be at least 32 characters")
+	check(c.Server.TicketSecret == "" || len(c.Server.TicketSecret) >= 32, "server.ticket_secret: must be at least 32 characters")
+	check(c.Server.TicketTTL > 0, "server.ticket_ttl: must be positive")
+
+	check(slices.Contains([]string{"kafka", "memory"}, c.Kafka.Backend), "kafka.backend: must be kafka or memory, got %q", c.Kafka.Backend)
+	check(c.Kafka.Topic != "", "kafka.topic: required")
+	check(c.Kafka.GroupID != "", "kafka.group_id: required")
+	check(slices.Contains([]string{"first", "last"}, c.Kafka.StartOffset), "kafka.start_offset: must be first or last, got %q", c.Kafka.StartOffset)
+	check(c.Kafka.Partitions > 0, "kafka.partitions: must be positive")
+	check(c.Kafka.BatchSize > 0, "kafka.batch_size: must be positive")
+	check(c.Kafka.BatchTimeout > 0, "kafka.batch_timeout: must be positive")
+	check(c.Kafka.SendInterval > 0, "kafka.send_interval: must be positive")
+	check(c.Kafka.RecvInterval > 0, "kafka.recv_interval: must be positive")
+	check(c.Kafka.ExpireInterval > 0, "kafka.expire_interval: must be positive")
+	check(c.Kafka.DeadLetterTopic != "" && c.Kafka.DeadLetterTopic != c.Kafka.Topic, "kafka.dead_letter_topic: required and must differ from kafka.topic")
+	check(c.Kafka.MaxAttempts > 0, "kafka.max_attempts: must be positive")
+	check(c.Kafka.PriorityWeight > 0, "kafka.priority_weight: must be positive")
+
+	check(slices.Contains([]string{"sink", "smtp"}, c.Delivery.DefaultChannel), "delivery.default_channel: must be sink or smtp, got %q", c.Delivery.DefaultChannel)
+	check(c.Delivery.Timeout > 0, "delivery.timeout: must be positive")
+	check(c.Delivery.SMTPAddr == "" || c.Delivery.SMTPFrom != "", "delivery.smtp_from: required with delivery.smtp_addr")
+	check(c.Delivery.DefaultChannel != "smtp" || c.Delivery.SMTPAddr != "", "delivery.smtp_addr: required when delivery.default_channel is smtp")
+
+	check(c.Callback.Timeout > 0, "callback.timeout: must be positive")
+	check(c.Callback.PollInterval > 0, "callback.poll_interval: must be positive")
+	check(c.Callback.BatchSize > 0, "callback.batch_size: must be positive")
+	check(c.Callback.MaxAttempts > 0, "callback.max_attempts: must be positive")
+	check(c.Callback.RetryInterval > 0 && c.Callback.RetryInterval <= c.Callback.MaxRetryInterval, "callback.retry_interval: must be positive and not exceed callback.max_retry_interval")
+
+	check(c.ShutdownTimeout > 0, "shutdown_timeout: must be positive")
+
+	return errors.Join(errs...)
+}
+
+// Masked возвращает копию конфигурации со скрытыми секретами для вывода.
+func (c Config) Masked() Config {
+	if u, err := url.Parse(c.DB.Addr); err == nil && u.User != nil {
+		c.DB.Addr = u.Redacted()
+	}
+	if c.Server.AdminKey != "" {
+		c.Server.AdminKey = "xxxxx"
+	}
+	if c.Server.JWTSecret != "" {
+		c.Server.JWTSecret = "xxxxx"
+	}
+	if c.Server.TicketSecret != "" {
+		c.Server.TicketSecret = "xxxxx"
+	}
+	if c.Delivery.SMTPPassword != "" {
+		c.Delivery.SMTPPassword = "xxxxx"
+	}
+	if c.Callback.Secret != "" {
+		c.Callback.Secret = "xxxxx"
+	}
+	return c
 }
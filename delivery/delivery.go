@@ -0,0 +1,114 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"messaggio/config"
+	"messaggio/model"
+)
+
+// ErrPermanent помечает ошибки, при которых повторная доставка не поможет:
+// неизвестный адрес, отказ получателя и т.п. Такие сообщения сразу переводятся в error.
+var ErrPermanent = errors.New("permanent delivery failure")
+
+// Channel доставляет сообщение получателю to. nil означает, что получатель подтвердил приём.
+type Channel interface {
+	Deliver(ctx context.Context, to string, msg model.Message) error
+}
+
+const (
+	Webhook = "webhook"
+	SMTP    = "smtp"
+	Sink    = "sink"
+)
+
+// Router выбирает канал по схеме адреса To:
+//
+//	http://…, https://…  — webhook
+//	mailto:…, user@host  — smtp
+//	sink:…               — sink (файл или stdout)
+//
+// Адреса без схемы доставляются через канал по умолчанию.
+type Router struct {
+	cfg      config.Delivery
+	channels map[string]Channel
+	sink     *FileSink
+}
+
+func New(cfg config.Delivery) (*Router, error) {
+	sink, err := NewFileSink(cfg.SinkFile)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Router{
+		cfg: cfg,
+		channels: map[string]Channel{
+			Webhook: NewWebhook(cfg),
+			Sink:    sink,
+		},
+		sink: sink,
+	}
+	if cfg.SMTPAddr != "" {
+		r.channels[SMTP] = NewSMTP(cfg)
+	}
+
+	return r, nil
+}
+
+func (r *Router) Close() {
+	_ = r.sink.Close()
+}
+
+// Route возвращает имя канала и адрес получателя в формате этого канала.
+func (r *Router) Route(to string) (string, string, error) {
+	return Route(to, r.cfg.DefaultChannel)
+}
+
+// Route выбирает канал по адресу to без обращения к самим каналам,
+// адреса без схемы уходят в defaultChannel.
+func Route(to, defaultChannel string) (string, string, error) {
+	u, err := url.Parse(to)
+	if err != nil || u.Scheme == "" {
+		if strings.Contains(to, "@") {
+			return SMTP, to, nil
+		}
+		return defaultChannel, to, nil
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return Webhook, to, nil
+	case "mailto":
+		return SMTP, u.Opaque, nil
+	case "sink":
+		return Sink, u.Opaque, nil
+	default:
+		return "", "", fmt.Errorf("%w: unsupported address scheme %q", ErrPermanent, u.Scheme)
+	}
+}
+
+// Deliver доставляет msg через канал, выбранный по msg.To, и возвращает имя канала.
+func (r *Router) Deliver(ctx context.Context, msg model.Message) (string, error) {
+	name, to, err := r.Route(msg.To)
+	if err != nil {
+		return "unknown", err
+	}
+
+	ch, ok := r.channels[name]
+	if !ok {
+		return name, fmt.Errorf("%w: channel %s is not configured", ErrPermanent, name)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+
+	if err = ch.Deliver(ctx, to, msg); err != nil {
+		return name, fmt.Errorf("%s: %w", name, err)
+	}
+	return name, nil
+}
@@ -0,0 +1,54 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"messaggio/model"
+)
+
+// FileSink записывает доставленные сообщения JSON-строками в файл или stdout.
+// Нужен для локальной разработки и тестов, доставка подтверждается сразу после записи.
+type FileSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewFileSink открывает path на дозапись, пустой path — stdout.
+func NewFileSink(path string) (*FileSink, error) {
+	if path == "" {
+		return &FileSink{w: os.Stdout}, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{w: f, closer: f}, nil
+}
+
+func (s *FileSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+func (s *FileSink) Deliver(_ context.Context, to string, msg model.Message) error {
+	data, err := json.Marshal(struct {
+		To      string        `json:"to"`
+		Message model.Message `json:"message"`
+	}{to, msg})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
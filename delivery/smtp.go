@@ -0,0 +1,110 @@
+package delivery
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"messaggio/config"
+	"messaggio/model"
+)
+
+// SMTPChannel отправляет сообщение письмом через SMTP-сервер из конфигурации.
+// Доставка подтверждается принятием письма сервером, коды 5xx считаются окончательным отказом.
+type SMTPChannel struct {
+	cfg config.Delivery
+}
+
+func NewSMTP(cfg config.Delivery) *SMTPChannel {
+	return &SMTPChannel{cfg: cfg}
+}
+
+func (c *SMTPChannel) Deliver(ctx context.Context, to string, msg model.Message) error {
+	err := c.send(ctx, to, msg)
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+		return fmt.Errorf("%w: %s", ErrPermanent, err)
+	}
+	return err
+}
+
+func (c *SMTPChannel) send(ctx context.Context, to string, msg model.Message) error {
+	host, _, err := net.SplitHostPort(c.cfg.SMTPAddr)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.cfg.SMTPAddr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if c.cfg.SMTPUsername != "" {
+		if err = client.Auth(smtp.PlainAuth("", c.cfg.SMTPUsername, c.cfg.SMTPPassword, host)); err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(c.cfg.SMTPFrom); err != nil {
+		return err
+	}
+	if err = client.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(mail(c.cfg.SMTPFrom, to, msg)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+var headerEscaper = strings.NewReplacer("\r", "", "\n", "")
+
+func mail(from, to string, msg model.Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", headerEscaper.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerEscaper.Replace(to))
+	if msg.From != "" {
+		fmt.Fprintf(&b, "Subject: Message from %s\r\n", headerEscaper.Replace(msg.From))
+	}
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Unix(msg.Timestamp, 0).UTC().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%d@messaggio>\r\n", msg.ID)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Content, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
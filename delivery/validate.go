@@ -0,0 +1,45 @@
+package delivery
+
+import (
+	"errors"
+	netmail "net/mail"
+	"net/url"
+
+	"messaggio/config"
+	"messaggio/netguard"
+)
+
+var (
+	ErrInvalidURL   = errors.New("invalid webhook URL")
+	ErrInvalidEmail = errors.New("invalid email address")
+	ErrEmptyAddress = errors.New("empty address")
+)
+
+// Validate проверяет, что адрес to подходит каналу, в который он будет направлен,
+// и возвращает имя канала. Настроен ли канал, не проверяется: это знает только broker.
+// Webhook на явно заданные внутренние адреса отклоняется сразу, имена проверяются при доставке.
+func Validate(to string, cfg config.Delivery) (string, error) {
+	channel, addr, err := Route(to, cfg.DefaultChannel)
+	if err != nil {
+		return "", err
+	}
+
+	switch channel {
+	case Webhook:
+		u, err := url.Parse(addr)
+		if err != nil || u.Host == "" || (!cfg.WebhookAllowPrivate && netguard.ForbiddenHost(u.Hostname())) {
+			return channel, ErrInvalidURL
+		}
+	case SMTP:
+		// Принимается только голый адрес, без отображаемого имени
+		parsed, err := netmail.ParseAddress(addr)
+		if err != nil || parsed.Address != addr {
+			return channel, ErrInvalidEmail
+		}
+	default:
+		if addr == "" {
+			return channel, ErrEmptyAddress
+		}
+	}
+	return channel, nil
+}
@@ -0,0 +1,79 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"messaggio/config"
+	"messaggio/model"
+	"messaggio/netguard"
+)
+
+// webhookPayload — тело запроса к получателю. Служебные поля сообщения (владелец,
+// ключ идемпотентности, callback_url, текст ошибки) сторонним адресам не передаются.
+type webhookPayload struct {
+	ID      int    `json:"id"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Content string `json:"content"`
+}
+
+// WebhookChannel отправляет сообщение POST-запросом с JSON-телом на адрес получателя.
+// Доставка подтверждается ответом 2xx, ответы 4xx (кроме 408 и 429) считаются окончательным отказом.
+// Внутренние адреса запрещены, если это не разрешено в настройках, перенаправления не выполняются.
+type WebhookChannel struct {
+	client *http.Client
+}
+
+func NewWebhook(cfg config.Delivery) *WebhookChannel {
+	client := netguard.NewClient(0)
+	if cfg.WebhookAllowPrivate {
+		client.Transport = http.DefaultTransport
+	}
+	return &WebhookChannel{client: client}
+}
+
+func (c *WebhookChannel) Deliver(ctx context.Context, to string, msg model.Message) error {
+	body, err := json.Marshal(webhookPayload{
+		ID:      msg.ID,
+		From:    msg.From,
+		To:      msg.To,
+		Content: msg.Content,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, to, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Доставка at-least-once: по этому заголовку получатель может отбросить повтор
+	req.Header.Set("Idempotency-Key", strconv.Itoa(msg.ID))
+
+	resp, err := c.client.Do(req)
+	if errors.Is(err, netguard.ErrForbiddenAddress) {
+		return fmt.Errorf("%w: %s", ErrPermanent, err)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("unexpected response %s", resp.Status)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return fmt.Errorf("%w: unexpected response %s", ErrPermanent, resp.Status)
+	default:
+		return fmt.Errorf("unexpected response %s", resp.Status)
+	}
+}
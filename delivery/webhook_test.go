@@ -0,0 +1,137 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"messaggio/config"
+	"messaggio/model"
+)
+
+func TestWebhookDeliver(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		wantErr       bool
+		wantPermanent bool
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "accepted", status: http.StatusAccepted},
+		{name: "rate limited", status: http.StatusTooManyRequests, wantErr: true},
+		{name: "server error", status: http.StatusBadGateway, wantErr: true},
+		{name: "rejected", status: http.StatusNotFound, wantErr: true, wantPermanent: true},
+	}
+
+	msg := model.Message{
+		ID:             42,
+		Content:        "hello",
+		From:           "alice",
+		To:             "http://example.com/hook",
+		Owner:          "key:alice",
+		IdempotencyKey: "k1",
+		CallbackURL:    "http://example.com/callback",
+		LastError:      "boom",
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]interface{}
+			var key string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				key = r.Header.Get("Idempotency-Key")
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			err := NewWebhook(config.Delivery{WebhookAllowPrivate: true}).Deliver(context.Background(), srv.URL, msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrPermanent) != tt.wantPermanent {
+				t.Errorf("permanent = %v, want %v", errors.Is(err, ErrPermanent), tt.wantPermanent)
+			}
+
+			// Получатель видит только id, from, to и content
+			want := map[string]interface{}{"id": float64(42), "from": "alice", "to": msg.To, "content": "hello"}
+			if len(body) != len(want) {
+				t.Errorf("body = %v, want %v", body, want)
+			}
+			for k, v := range want {
+				if body[k] != v {
+					t.Errorf("body[%q] = %v, want %v", k, body[k], v)
+				}
+			}
+			if key != "42" {
+				t.Errorf("Idempotency-Key = %q, want %q", key, "42")
+			}
+		})
+	}
+}
+
+func TestWebhookPrivate(t *testing.T) {
+	tests := []struct {
+		name          string
+		allowPrivate  bool
+		redirect      bool
+		wantCalls     int
+		wantErr       bool
+		wantPermanent bool
+	}{
+		{name: "loopback forbidden", wantErr: true, wantPermanent: true},
+		{name: "loopback allowed", allowPrivate: true, wantCalls: 1},
+		{name: "redirect not followed", allowPrivate: true, redirect: true, wantCalls: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if tt.redirect && r.URL.Path == "/" {
+					http.Redirect(w, r, "/internal", http.StatusTemporaryRedirect)
+				}
+			}))
+			defer srv.Close()
+
+			err := NewWebhook(config.Delivery{WebhookAllowPrivate: tt.allowPrivate}).Deliver(context.Background(), srv.URL, model.Message{ID: 1})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrPermanent) != tt.wantPermanent {
+				t.Errorf("permanent = %v, want %v", errors.Is(err, ErrPermanent), tt.wantPermanent)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestValidateWebhook(t *testing.T) {
+	tests := []struct {
+		name         string
+		to           string
+		allowPrivate bool
+		wantErr      error
+	}{
+		{name: "public", to: "https://example.com/hook"},
+		{name: "loopback", to: "http://127.0.0.1:8081/", wantErr: ErrInvalidURL},
+		{name: "metadata", to: "http://169.254.169.254/latest", wantErr: ErrInvalidURL},
+		{name: "localhost", to: "http://localhost/hook", wantErr: ErrInvalidURL},
+		{name: "loopback allowed", to: "http://127.0.0.1:8081/", allowPrivate: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Validate(tt.to, config.Delivery{DefaultChannel: Sink, WebhookAllowPrivate: tt.allowPrivate})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -4,6 +4,22 @@ import (
 	"messaggio/cmd"
 )
 
+// Общее описание API: swag init читает его из main.go.
+// Пути в аннотациях обработчиков уже начинаются с /api, поэтому BasePath — корень.
+//
+// @title Messaggio API
+// @version 1.0
+// @description This is a simple message broker
+// @description Errors carry a stable machine-readable code. Error texts follow Accept-Language (ru by default, en),
+// @description and clients sending Accept: application/problem+json receive RFC 7807 problem details.
+// @host localhost:8080
+// @BasePath /
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
 func main() {
 	cmd.Execute()
 }
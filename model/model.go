@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type Message struct {
 	ID        int    `json:"id" pg:",pk,notnull"`
 	Content   string `json:"content" pg:",notnull"`
@@ -7,17 +9,132 @@ type Message struct {
 	To        string `json:"to" pg:",notnull"`
 	Timestamp int64  `json:"timestamp" pg:",notnull,default:extract(epoch from now())"`
 	Status    string `json:"status" pg:",notnull,default:'new'"`
+	Attempts  int    `json:"attempts" pg:",use_zero,notnull,default:0"`
+	LastError string `json:"last_error,omitempty"`
+
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	CallbackURL    string `json:"callback_url,omitempty"`
+
+	// SendAt — unix-время, раньше которого сообщение не отправляется, 0 — сразу
+	SendAt int64 `json:"send_at,omitempty"`
+
+	Priority int `json:"priority,omitempty" pg:",use_zero,notnull,default:0"`
+
+	// ExpiresAt — unix-время, после которого недоставленное сообщение переводится в expired, 0 — без срока
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	// TTL — срок жизни из запроса в секундах, по нему повтор с ключом идемпотентности сверяется с исходным
+	TTL int64 `json:"ttl,omitempty"`
+
+	// Owner — имя API-ключа или subject JWT, которым создано сообщение
+	Owner string `json:"owner,omitempty"`
+}
+
+// Expired сообщает, что срок жизни сообщения истёк к моменту now.
+func (m Message) Expired(now time.Time) bool {
+	return m.ExpiresAt != 0 && m.ExpiresAt <= now.Unix()
 }
 
+// Сообщения с большим приоритетом отправляются раньше и читаются из брокера чаще.
+const (
+	PriorityLow    = -1
+	PriorityNormal = 0
+	PriorityHigh   = 1
+)
+
 type Status string
 
 const (
 	New        Status = "new"
+	Scheduled  Status = "scheduled"
 	Processing Status = "processing"
 	Ok         Status = "ok"
 	Error      Status = "error"
+	Canceled   Status = "canceled"
+	Expired    Status = "expired"
 )
 
 func (s Status) String() string {
 	return string(s)
 }
+
+// Final сообщает, что статус окончательный: о переходе в него уведомляется callback_url.
+func (s Status) Final() bool {
+	return s == Ok || s == Error || s == Canceled || s == Expired
+}
+
+type Event struct {
+	tableName struct{} `pg:"message_events"`
+
+	ID        int       `json:"id" pg:",pk"`
+	MessageID int       `json:"message_id" pg:",notnull"`
+	Status    string    `json:"status" pg:",notnull"`
+	Timestamp time.Time `json:"timestamp" pg:",notnull,default:now()"`
+	Instance  string    `json:"instance,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	From      string    `json:"from,omitempty" pg:"-"`
+	To        string    `json:"to,omitempty" pg:"-"`
+	Owner     string    `json:"owner,omitempty" pg:"-"`
+}
+
+// Callback — уведомление клиента о переходе сообщения в окончательный статус,
+// ожидающее отправки на callback_url сообщения.
+type Callback struct {
+	ID            int       `json:"id" pg:",pk"`
+	MessageID     int       `json:"message_id" pg:",notnull"`
+	URL           string    `json:"url" pg:",notnull"`
+	Status        string    `json:"status" pg:",notnull"`
+	Error         string    `json:"error,omitempty"`
+	Timestamp     time.Time `json:"timestamp" pg:",notnull,default:now()"`
+	State         string    `json:"state" pg:",notnull,default:'pending'"`
+	Attempts      int       `json:"attempts" pg:",use_zero,notnull,default:0"`
+	NextAttemptAt time.Time `json:"next_attempt_at" pg:",notnull,default:now()"`
+}
+
+type CallbackState string
+
+const (
+	CallbackPending   CallbackState = "pending"
+	CallbackDelivered CallbackState = "delivered"
+	CallbackFailed    CallbackState = "failed"
+)
+
+func (s CallbackState) String() string {
+	return string(s)
+}
+
+// CallbackAttempt — результат одной попытки отправить Callback.
+type CallbackAttempt struct {
+	tableName struct{} `pg:"callback_attempts"`
+
+	ID           int       `json:"id" pg:",pk"`
+	CallbackID   int       `json:"callback_id" pg:",notnull"`
+	MessageID    int       `json:"message_id" pg:",notnull"`
+	Attempt      int       `json:"attempt" pg:",notnull"`
+	State        string    `json:"state" pg:",notnull"`
+	ResponseCode int       `json:"response_code,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Timestamp    time.Time `json:"timestamp" pg:",notnull,default:now()"`
+}
+
+// Области доступа API-ключей: messages — создание и чтение своих сообщений,
+// admin — все сообщения, метрики и служебные эндпоинты.
+const (
+	ScopeMessages = "messages"
+	ScopeAdmin    = "admin"
+)
+
+// APIKey — ключ доступа к API. Хранится только SHA-256 хэш, сам ключ показывается один раз при создании.
+// Ключи с одинаковым именем видят одни и те же сообщения, так ключ можно заменить без потери доступа.
+type APIKey struct {
+	tableName struct{} `pg:"api_keys"`
+
+	ID        int        `json:"id" pg:",pk"`
+	Name      string     `json:"name" pg:",notnull"`
+	KeyHash   string     `json:"-" pg:",notnull"`
+	Scopes    []string   `json:"scopes" pg:",array,notnull"`
+	CreatedAt time.Time  `json:"created_at" pg:",notnull,default:now()"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+
+	// DailyQuota — сообщений в сутки для этого ключа, 0 — квота из конфигурации
+	DailyQuota int `json:"daily_quota,omitempty" pg:",use_zero,notnull,default:0"`
+}
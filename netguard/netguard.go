@@ -0,0 +1,77 @@
+package netguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress — адрес закрыт для исходящих запросов на адреса клиентов.
+var ErrForbiddenAddress = errors.New("forbidden address")
+
+// Forbidden сообщает, что ip нельзя использовать как адрес получателя: loopback, частные сети,
+// link-local (в том числе 169.254.169.254), multicast и неуказанный адрес.
+func Forbidden(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return !ip.IsValid() ||
+		ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified()
+}
+
+// ForbiddenHost сообщает, что host из URL заведомо указывает на внутренний адрес: localhost
+// или запрещённый IP. Имена проверяются окончательно только при соединении.
+func ForbiddenHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip, err := netip.ParseAddr(strings.Trim(host, "[]"))
+	return err == nil && Forbidden(ip)
+}
+
+// control проверяет адрес уже после разрешения имени, поэтому DNS-записи,
+// указывающие на внутренние адреса, тоже отклоняются.
+func control(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, address)
+	}
+	if Forbidden(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// NewClient возвращает HTTP-клиент для запросов на адреса, заданные клиентами API:
+// соединения с внутренними адресами запрещены, перенаправления не выполняются,
+// вызывающий получает сам ответ 3xx.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   control,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Через прокси проверялся бы адрес прокси, а не получателя
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,106 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestForbidden(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "127.0.0.1", want: true},
+		{addr: "::1", want: true},
+		{addr: "10.0.0.5", want: true},
+		{addr: "172.18.0.3", want: true},
+		{addr: "192.168.1.1", want: true},
+		{addr: "169.254.169.254", want: true},
+		{addr: "fe80::1", want: true},
+		{addr: "fd00::1", want: true},
+		{addr: "0.0.0.0", want: true},
+		{addr: "::ffff:127.0.0.1", want: true},
+		{addr: "224.0.0.1", want: true},
+		{addr: "93.184.216.34", want: false},
+		{addr: "2606:2800:220:1::1", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := Forbidden(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Errorf("Forbidden(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForbiddenHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{host: "localhost", want: true},
+		{host: "LocalHost.", want: true},
+		{host: "api.localhost", want: true},
+		{host: "169.254.169.254", want: true},
+		{host: "[::1]", want: true},
+		{host: "example.com", want: false},
+		{host: "app-broker", want: false},
+		{host: "93.184.216.34", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := ForbiddenHost(tt.host); got != tt.want {
+				t.Errorf("ForbiddenHost(%s) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientLoopback(t *testing.T) {
+	var called bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, srv.URL, nil)
+	_, err := NewClient(time.Second).Do(req)
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatalf("error = %v, want %v", err, ErrForbiddenAddress)
+	}
+	if called {
+		t.Error("request reached loopback server")
+	}
+}
+
+func TestClientRedirect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/internal", http.StatusFound)
+			return
+		}
+		t.Errorf("redirect to %s followed", r.URL.Path)
+	}))
+	defer srv.Close()
+
+	// Проверяется только политика перенаправлений, соединение с loopback разрешено
+	client := NewClient(time.Second)
+	client.Transport = srv.Client().Transport
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, srv.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+}
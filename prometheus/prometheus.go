@@ -2,25 +2,31 @@ package prometheus
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"messaggio/model"
 )
 
 type Prometheus struct {
-	NewMessageGauge        prometheus.Gauge
-	ProcessingMessageGauge prometheus.Gauge
-	OkMessageCounter       prometheus.Counter
-	ErrorMessageCounter    prometheus.Counter
+	OkMessageCounter         prometheus.Counter
+	ErrorMessageCounter      prometheus.Counter
+	RetryMessageCounter      prometheus.Counter
+	SentMessageCounter       prometheus.Counter
+	DeadLetterMessageCounter prometheus.Counter
+	SendBatchSize            prometheus.Histogram
+	SendDuration             prometheus.Histogram
+	DeliveryLatency          prometheus.Histogram
+	ChannelDeliveryCounter   *prometheus.CounterVec
+	CallbackCounter          *prometheus.CounterVec
+	ExpiredMessageCounter    *prometheus.CounterVec
+	RejectedRequestCounter   *prometheus.CounterVec
 }
 
-func New() *Prometheus {
+type StatusCounter interface {
+	CountByStatus() (map[model.Status]int, error)
+}
+
+func New(counter StatusCounter) *Prometheus {
 	pr := Prometheus{
-		NewMessageGauge: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "new_message_gauge",
-			Help: "The total number of new messages",
-		}),
-		ProcessingMessageGauge: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "processing_message_gauge",
-			Help: "The total number of processing messages",
-		}),
 		OkMessageCounter: prometheus.NewCounter(prometheus.CounterOpts{
 			Name: "ok_message_counter",
 			Help: "The total number of ok messages",
@@ -29,9 +35,102 @@ func New() *Prometheus {
 			Name: "error_message_counter",
 			Help: "The total number of error messages",
 		}),
+		RetryMessageCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "retry_message_counter",
+			Help: "The total number of failed attempts returned for retry",
+		}),
+		SentMessageCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "sent_message_counter",
+			Help: "The total number of messages published to the broker",
+		}),
+		DeadLetterMessageCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dead_letter_message_counter",
+			Help: "The total number of messages moved to the dead-letter topic",
+		}),
+		SendBatchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "send_batch_size",
+			Help:    "The number of messages in a published batch",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+		}),
+		SendDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "send_duration_seconds",
+			Help:    "The time spent publishing a batch to the broker",
+			Buckets: prometheus.DefBuckets,
+		}),
+		DeliveryLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "delivery_latency_seconds",
+			Help:    "The time between message creation and its ok status",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+		}),
+		ChannelDeliveryCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "channel_delivery_counter",
+			Help: "The total number of delivery attempts by channel and result",
+		}, []string{"channel", "result"}),
+		CallbackCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "callback_counter",
+			Help: "The total number of callback attempts by resulting state",
+		}, []string{"state"}),
+		ExpiredMessageCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "expired_message_counter",
+			Help: "The total number of messages expired by TTL by the status they expired in",
+		}, []string{"status"}),
+		RejectedRequestCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rejected_request_counter",
+			Help: "The total number of API requests rejected with 429 by reason: ip_rate_limit, rate_limit or quota",
+		}, []string{"reason"}),
 	}
 
-	prometheus.MustRegister(pr.NewMessageGauge, pr.ProcessingMessageGauge, pr.OkMessageCounter, pr.ErrorMessageCounter)
+	prometheus.MustRegister(
+		pr.OkMessageCounter,
+		pr.ErrorMessageCounter,
+		pr.RetryMessageCounter,
+		pr.SentMessageCounter,
+		pr.DeadLetterMessageCounter,
+		pr.SendBatchSize,
+		pr.SendDuration,
+		pr.DeliveryLatency,
+		pr.ChannelDeliveryCounter,
+		pr.CallbackCounter,
+		pr.ExpiredMessageCounter,
+		pr.RejectedRequestCounter,
+		newStatusCollector(counter),
+	)
 
 	return &pr
 }
+
+// statusCollector считает new, scheduled и processing сообщения в базе при каждом scrape,
+// поэтому значения не теряются при перезапуске процессов.
+type statusCollector struct {
+	counter    StatusCounter
+	new        *prometheus.Desc
+	scheduled  *prometheus.Desc
+	processing *prometheus.Desc
+}
+
+func newStatusCollector(counter StatusCounter) *statusCollector {
+	return &statusCollector{
+		counter:    counter,
+		new:        prometheus.NewDesc("new_message_gauge", "The total number of new messages", nil, nil),
+		scheduled:  prometheus.NewDesc("scheduled_message_gauge", "The total number of scheduled messages", nil, nil),
+		processing: prometheus.NewDesc("processing_message_gauge", "The total number of processing messages", nil, nil),
+	}
+}
+
+func (c *statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.new
+	ch <- c.scheduled
+	ch <- c.processing
+}
+
+func (c *statusCollector) Collect(ch chan<- prometheus.Metric) {
+	counts, err := c.counter.CountByStatus()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.new, prometheus.GaugeValue, float64(counts[model.New]))
+	ch <- prometheus.MustNewConstMetric(c.scheduled, prometheus.GaugeValue, float64(counts[model.Scheduled]))
+	ch <- prometheus.MustNewConstMetric(c.processing, prometheus.GaugeValue, float64(counts[model.Processing]))
+}
@@ -2,78 +2,186 @@ package receiver
 
 import (
 	"context"
-	"net/http"
-	"sync"
+	"errors"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"messaggio/broker"
 	"messaggio/config"
+	"messaggio/delivery"
 	"messaggio/model"
+	"messaggio/prometheus"
 	"messaggio/storage"
 )
 
+const (
+	// retryBackoff — пауза перед первым повтором записи статуса или dead letter, удваивается до maxRetryBackoff
+	retryBackoff    = 100 * time.Millisecond
+	maxRetryBackoff = 5 * time.Second
+)
+
 type Worker struct {
-	broker  *broker.Broker
-	storage *storage.Storage
-	wg      sync.WaitGroup
-	closeR  chan struct{}
-	cfg     config.Broker
+	broker     broker.Consumer
+	delivery   *delivery.Router
+	storage    storage.Repository
+	prometheus *prometheus.Prometheus
+	cfg        config.Broker
 }
 
-func New(b *broker.Broker, s *storage.Storage, cfg config.Broker) *Worker {
+func New(b broker.Consumer, d *delivery.Router, s storage.Repository, p *prometheus.Prometheus, cfg config.Broker) *Worker {
 	return &Worker{
-		broker:  b,
-		storage: s,
-		closeR:  make(chan struct{}),
-		cfg:     cfg,
+		broker:     b,
+		delivery:   d,
+		storage:    s,
+		prometheus: p,
+		cfg:        cfg,
 	}
 }
 
-func (w *Worker) Start(ctx context.Context) {
-	w.wg.Add(1)
-	go func() {
-		for {
+// Run читает сообщения из брокера до отмены ctx. Recv сам ждёт следующего сообщения,
+// пауза RecvInterval выдерживается только после ошибки чтения. Отмена прерывает ожидание
+// следующего сообщения и повторы записи статуса: без записи offset не фиксируется,
+// и после перезапуска сообщение будет прочитано снова.
+func (w *Worker) Run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		d, err := w.broker.Recv(ctx)
+		switch {
+		case err == nil:
+			w.receive(ctx, d)
+		case errors.Is(err, broker.ErrMalformed):
+			log.Error(err)
+			w.deadLetter(ctx, d, err)
+		case ctx.Err() == nil:
+			log.Error(err)
 			select {
-			case <-w.closeR:
-				log.Info("receiver stopped")
-				return
-			case <-time.After(100 * time.Millisecond):
-				msg, err := w.broker.Recv(ctx)
-				if err != nil {
-					log.Error(err)
-					continue
-				}
-
-				client := http.Client{}
-				request, err := http.NewRequest("PUT", "http://"+w.cfg.ServerAddr+"/api/messages/processing/sub/1", nil)
-				if err == nil {
-					if _, err = client.Do(request); err != nil {
-						log.Error(err)
-					}
-				} else {
-					log.Error(err)
-				}
-
-				request, err = http.NewRequest("PUT", "http://"+w.cfg.ServerAddr+"/api/messages/ok/add/1", nil)
-				if err == nil {
-					if _, err = client.Do(request); err != nil {
-						log.Error(err)
-					}
-				} else {
-					log.Error(err)
-				}
-
-				if err = w.storage.UpdateStatus(msg.ID, model.Ok); err != nil {
-					log.Error(err)
-					continue
-				}
+			case <-ctx.Done():
+			case <-time.After(w.cfg.RecvInterval):
 			}
 		}
-	}()
+	}
+
+	log.Info("receiver stopped")
+	return nil
+}
+
+// receive доставляет сообщение получателю и переводит его в ok только после подтверждения канала.
+// ctx прерывает только повторы записи статуса, доставка и фиксация offset выполняются без отмены.
+func (w *Worker) receive(ctx context.Context, d broker.Delivery) {
+	work := context.WithoutCancel(ctx)
+
+	// Просроченное сообщение не доставляется, в expired его переведёт sweeper
+	if d.Message.Expired(time.Now()) {
+		log.Warnf("message %d expired, delivery skipped", d.Message.ID)
+		w.commit(ctx, d)
+		return
+	}
+
+	channel, err := w.delivery.Deliver(work, d.Message)
+	if err != nil {
+		log.Error(err)
+		w.prometheus.ChannelDeliveryCounter.WithLabelValues(channel, "error").Inc()
+		w.fail(ctx, d, err)
+		return
+	}
+	w.prometheus.ChannelDeliveryCounter.WithLabelValues(channel, "ok").Inc()
+
+	// Получатель уже подтвердил приём: повторяется только запись статуса, не доставка.
+	// Offset фиксируется только после записи, иначе сообщение навсегда осталось бы в processing.
+	err = retry(ctx, func() error { return w.storage.UpdateStatus(d.Message.ID, model.Ok) }, func(err error) bool {
+		return errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrStatusLocked)
+	})
+	switch {
+	case errors.Is(err, storage.ErrStatusLocked):
+		log.Warnf("message %d delivered after it was canceled or expired, status ok is not saved", d.Message.ID)
+	case errors.Is(err, storage.ErrNotFound):
+		log.Errorf("message %d delivered, but it is not found in storage", d.Message.ID)
+	case err != nil:
+		log.Errorf("message %d delivered, but status ok is not saved, offset is not committed: %s", d.Message.ID, err)
+		return
+	default:
+		w.prometheus.OkMessageCounter.Inc()
+		w.prometheus.DeliveryLatency.Observe(time.Since(time.Unix(d.Message.Timestamp, 0)).Seconds())
+	}
+
+	w.commit(ctx, d)
+}
+
+// retry повторяет fn с растущей паузой, пока она не завершится успешно, не вернёт ошибку,
+// для которой final возвращает true, или пока не будет отменён ctx.
+func retry(ctx context.Context, fn func() error, final func(error) bool) error {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || final(err) {
+			return err
+		}
+		log.Warnf("attempt %d failed, retrying in %s: %s", attempt, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+			backoff = min(backoff*2, maxRetryBackoff)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// fail фиксирует неудачную попытку обработки. Если попытки исчерпаны или ошибка
+// окончательная, сообщение переводится в error и уходит в dead-letter топик.
+// Запись повторяется до успеха: offset в Kafka фиксируется накопительно, и пропущенный
+// Commit перекрыл бы следующий, а сообщение потерялось бы в processing.
+func (w *Worker) fail(ctx context.Context, d broker.Delivery, reason error) {
+	maxAttempts := w.cfg.MaxAttempts
+	if errors.Is(reason, delivery.ErrPermanent) {
+		maxAttempts = 1
+	}
+
+	var status model.Status
+	err := retry(ctx, func() (err error) {
+		status, err = w.storage.Fail(d.Message.ID, reason.Error(), maxAttempts)
+		return err
+	}, func(err error) bool {
+		return errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrStatusLocked)
+	})
+	switch {
+	case errors.Is(err, storage.ErrStatusLocked):
+		log.Warnf("message %d failed after it was canceled or expired, attempt is not saved", d.Message.ID)
+		w.commit(ctx, d)
+		return
+	case errors.Is(err, storage.ErrNotFound):
+		log.Errorf("message %d failed, but it is not found in storage", d.Message.ID)
+		w.commit(ctx, d)
+		return
+	case err != nil:
+		log.Errorf("message %d: failed attempt is not saved, offset is not committed: %s", d.Message.ID, err)
+		return
+	}
+
+	if status != model.Error {
+		w.prometheus.RetryMessageCounter.Inc()
+		w.commit(ctx, d)
+		return
+	}
+
+	w.deadLetter(ctx, d, reason)
+}
+
+// deadLetter отправляет сообщение в dead-letter топик, повторяя отправку до успеха, и только потом фиксирует offset.
+func (w *Worker) deadLetter(ctx context.Context, d broker.Delivery, reason error) {
+	work := context.WithoutCancel(ctx)
+	err := retry(ctx, func() error { return w.broker.DeadLetter(work, d, reason) }, func(error) bool { return false })
+	if err != nil {
+		log.Errorf("message at offset %d is not moved to dead letter, offset is not committed: %s", d.Offset, err)
+		return
+	}
+
+	w.prometheus.ErrorMessageCounter.Inc()
+	w.prometheus.DeadLetterMessageCounter.Inc()
+	w.commit(ctx, d)
 }
 
-func (w *Worker) Close() {
-	close(w.closeR)
-	w.wg.Wait()
+func (w *Worker) commit(ctx context.Context, d broker.Delivery) {
+	if err := w.broker.Commit(context.WithoutCancel(ctx), d); err != nil {
+		log.Error(err)
+	}
 }
@@ -0,0 +1,358 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"messaggio/broker"
+	"messaggio/config"
+	"messaggio/delivery"
+	"messaggio/model"
+	"messaggio/prometheus"
+	"messaggio/storage"
+)
+
+// Метрики регистрируются глобально, поэтому экземпляр один на все тесты
+var testPrometheus = prometheus.New(storage.NewMemory(config.DataBase{}))
+
+// flakyStorage отвечает ErrUnavailable на первые failures записей статуса и первые failFailures
+// записей неудачных попыток. Если задан race, перед первой записью статуса сообщение переводится
+// в этот статус, как если бы receiver опередили.
+type flakyStorage struct {
+	storage.Repository
+	failures int
+	race     model.Status
+	calls    int
+
+	failFailures int
+	failCalls    int
+}
+
+func (s *flakyStorage) Fail(id int, reason string, maxAttempts int) (model.Status, error) {
+	s.failCalls++
+	if s.failCalls <= s.failFailures {
+		return "", storage.ErrUnavailable
+	}
+	return s.Repository.Fail(id, reason, maxAttempts)
+}
+
+func (s *flakyStorage) UpdateStatus(id int, status model.Status) error {
+	s.calls++
+	if s.calls == 1 && s.race != "" {
+		if err := s.Repository.UpdateStatus(id, s.race); err != nil {
+			return err
+		}
+	}
+	if s.calls <= s.failures {
+		return storage.ErrUnavailable
+	}
+	return s.Repository.UpdateStatus(id, status)
+}
+
+// commitBroker считает зафиксированные offset и отвечает ошибкой на первые deadLetterFailures отправок в dead letter
+type commitBroker struct {
+	*broker.Memory
+	commits int
+
+	deadLetterFailures int
+	deadLetterCalls    int
+}
+
+func (b *commitBroker) DeadLetter(ctx context.Context, d broker.Delivery, reason error) error {
+	b.deadLetterCalls++
+	if b.deadLetterCalls <= b.deadLetterFailures {
+		return errors.New("kafka unavailable")
+	}
+	return b.Memory.DeadLetter(ctx, d, reason)
+}
+
+func (b *commitBroker) Commit(ctx context.Context, d broker.Delivery) error {
+	b.commits++
+	return b.Memory.Commit(ctx, d)
+}
+
+func TestReceive(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		race     model.Status
+		// stopAfter отменяет контекст worker, как при остановке, 0 — без остановки
+		stopAfter time.Duration
+
+		wantStatus    model.Status
+		wantCalls     int // 0 — не проверяется
+		wantCommitted bool
+	}{
+		{name: "status saved", failures: 0, wantStatus: model.Ok, wantCalls: 1, wantCommitted: true},
+		{name: "status saved after retries", failures: 3, wantStatus: model.Ok, wantCalls: 4, wantCommitted: true},
+		{name: "status not saved before stop", failures: 1000, stopAfter: 300 * time.Millisecond, wantStatus: model.Processing},
+		{name: "expired by sweeper", race: model.Expired, wantStatus: model.Expired, wantCalls: 1, wantCommitted: true},
+		{name: "canceled meanwhile", race: model.Canceled, wantStatus: model.Canceled, wantCalls: 1, wantCommitted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.stopAfter > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.stopAfter)
+				defer cancel()
+			}
+			cfg := config.Default()
+
+			mem := storage.NewMemory(cfg.DB)
+			if err := mem.Insert(&model.Message{Content: "hello", From: "alice", To: "sink:test"}, nil); err != nil {
+				t.Fatal(err)
+			}
+			claim, err := mem.Claim(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = mem.Ack(claim); err != nil {
+				t.Fatal(err)
+			}
+
+			sink := filepath.Join(t.TempDir(), "sink.jsonl")
+			router, err := delivery.New(config.Delivery{SinkFile: sink, DefaultChannel: delivery.Sink})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer router.Close()
+
+			b := &commitBroker{Memory: broker.NewMemory(cfg.Kafka)}
+			defer b.Close()
+			if err = b.Send(ctx, claim.Messages); err != nil {
+				t.Fatal(err)
+			}
+			d, err := b.Recv(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			store := &flakyStorage{Repository: mem, failures: tt.failures, race: tt.race}
+			New(b, router, store, testPrometheus, cfg.Kafka).receive(ctx, d)
+
+			// Ошибка записи статуса не приводит к повторной доставке
+			data, err := os.ReadFile(sink)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := bytes.Count(data, []byte("\n")); n != 1 {
+				t.Errorf("delivered %d times, want 1", n)
+			}
+			if tt.wantCalls > 0 && store.calls != tt.wantCalls {
+				t.Errorf("status writes = %d, want %d", store.calls, tt.wantCalls)
+			}
+			// Offset фиксируется только после записи статуса, иначе сообщение прочитают снова после перезапуска
+			if committed := b.commits > 0; committed != tt.wantCommitted {
+				t.Errorf("committed = %v, want %v", committed, tt.wantCommitted)
+			}
+
+			msg, err := mem.SelectById(d.Message.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if model.Status(msg.Status) != tt.wantStatus {
+				t.Errorf("status = %s, want %s", msg.Status, tt.wantStatus)
+			}
+			if msg.Attempts != 0 {
+				t.Errorf("attempts = %d, want 0", msg.Attempts)
+			}
+		})
+	}
+}
+
+func TestRunWithoutPause(t *testing.T) {
+	cfg := config.Default()
+	cfg.Kafka.RecvInterval = time.Hour
+
+	mem := storage.NewMemory(cfg.DB)
+	msgs := make([]model.Message, 3)
+	for i := range msgs {
+		msgs[i] = model.Message{Content: "hello", From: "alice", To: "sink:test"}
+	}
+	if err := mem.InsertBatch(msgs, nil); err != nil {
+		t.Fatal(err)
+	}
+	claim, err := mem.Claim(context.Background(), len(msgs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = mem.Ack(claim); err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := delivery.New(config.Delivery{SinkFile: filepath.Join(t.TempDir(), "sink.jsonl"), DefaultChannel: delivery.Sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+
+	b := broker.NewMemory(cfg.Kafka)
+	defer b.Close()
+	if err = b.Send(context.Background(), claim.Messages); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = New(b, router, mem, testPrometheus, cfg.Kafka).Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Без паузы перед каждым Recv все сообщения доставляются задолго до RecvInterval
+	deadline := time.Now().Add(5 * time.Second)
+	for _, msg := range claim.Messages {
+		for {
+			stored, err := mem.SelectById(msg.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if model.Status(stored.Status) == model.Ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("message %d status = %s, want %s", msg.ID, stored.Status, model.Ok)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestFail(t *testing.T) {
+	tests := []struct {
+		name               string
+		status             int
+		failFailures       int
+		deadLetterFailures int
+		// race переводит сообщение в этот статус до обработки, как если бы receiver опередили
+		race model.Status
+		// stopAfter отменяет контекст worker, как при остановке, 0 — без остановки
+		stopAfter time.Duration
+
+		wantStatus      model.Status
+		wantDeadLetters int
+		wantCommitted   bool
+	}{
+		{name: "retry saved", status: http.StatusBadGateway, wantStatus: model.New, wantCommitted: true},
+		{name: "retry saved after failures", status: http.StatusBadGateway, failFailures: 3, wantStatus: model.New, wantCommitted: true},
+		{
+			name:          "retry not saved before stop",
+			status:        http.StatusBadGateway,
+			failFailures:  1000,
+			stopAfter:     300 * time.Millisecond,
+			wantStatus:    model.Processing,
+			wantCommitted: false,
+		},
+		{name: "expired by sweeper", status: http.StatusBadGateway, race: model.Expired, wantStatus: model.Expired, wantCommitted: true},
+		{name: "canceled meanwhile", status: http.StatusNotFound, race: model.Canceled, wantStatus: model.Canceled, wantCommitted: true},
+		{name: "dead letter", status: http.StatusNotFound, wantStatus: model.Error, wantDeadLetters: 1, wantCommitted: true},
+		{
+			name:               "dead letter after failures",
+			status:             http.StatusNotFound,
+			deadLetterFailures: 3,
+			wantStatus:         model.Error,
+			wantDeadLetters:    1,
+			wantCommitted:      true,
+		},
+		{
+			name:               "dead letter not sent before stop",
+			status:             http.StatusNotFound,
+			deadLetterFailures: 1000,
+			stopAfter:          300 * time.Millisecond,
+			wantStatus:         model.Error,
+			wantCommitted:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.stopAfter > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.stopAfter)
+				defer cancel()
+			}
+			cfg := config.Default()
+
+			hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer hook.Close()
+
+			mem := storage.NewMemory(cfg.DB)
+			if err := mem.Insert(&model.Message{Content: "hello", From: "alice", To: hook.URL}, nil); err != nil {
+				t.Fatal(err)
+			}
+			claim, err := mem.Claim(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = mem.Ack(claim); err != nil {
+				t.Fatal(err)
+			}
+
+			router, err := delivery.New(config.Delivery{DefaultChannel: delivery.Sink, Timeout: time.Second, WebhookAllowPrivate: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer router.Close()
+
+			b := &commitBroker{Memory: broker.NewMemory(cfg.Kafka), deadLetterFailures: tt.deadLetterFailures}
+			defer b.Close()
+			if err = b.Send(ctx, claim.Messages); err != nil {
+				t.Fatal(err)
+			}
+			d, err := b.Recv(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.race != "" {
+				if err = mem.UpdateStatus(d.Message.ID, tt.race); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			store := &flakyStorage{Repository: mem, failFailures: tt.failFailures}
+			New(b, router, store, testPrometheus, cfg.Kafka).receive(ctx, d)
+
+			// Пропущенный Commit без записи результата перекрылся бы Commit следующего сообщения
+			if committed := b.commits > 0; committed != tt.wantCommitted {
+				t.Errorf("committed = %v, want %v", committed, tt.wantCommitted)
+			}
+			if n := len(b.DeadLetters()); n != tt.wantDeadLetters {
+				t.Errorf("dead letters = %d, want %d", n, tt.wantDeadLetters)
+			}
+
+			msg, err := mem.SelectById(d.Message.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if model.Status(msg.Status) != tt.wantStatus {
+				t.Errorf("status = %s, want %s", msg.Status, tt.wantStatus)
+			}
+
+			// Отменённое или просроченное сообщение не получает лишних событий и второго окончательного статуса
+			events, err := mem.SelectEvents(d.Message.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last := events[len(events)-1]; model.Status(last.Status) != tt.wantStatus {
+				t.Errorf("last event status = %s, want %s", last.Status, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -2,89 +2,81 @@ package sender
 
 import (
 	"context"
-	"net/http"
-	"strconv"
-	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"messaggio/broker"
 	"messaggio/config"
-	"messaggio/model"
+	"messaggio/prometheus"
 	"messaggio/storage"
 )
 
 type Worker struct {
-	broker  *broker.Broker
-	storage *storage.Storage
-	wg      sync.WaitGroup
-	closeS  chan struct{}
-	cfg     config.Broker
+	broker     broker.Publisher
+	storage    storage.Repository
+	prometheus *prometheus.Prometheus
+	cfg        config.Broker
 }
 
-func New(b *broker.Broker, s *storage.Storage, cfg config.Broker) *Worker {
+func New(b broker.Publisher, s storage.Repository, p *prometheus.Prometheus, cfg config.Broker) *Worker {
 	return &Worker{
-		broker:  b,
-		storage: s,
-		closeS:  make(chan struct{}),
-		cfg:     cfg,
+		broker:     b,
+		storage:    s,
+		prometheus: p,
+		cfg:        cfg,
 	}
 }
 
-func (w *Worker) Start(ctx context.Context) {
-	w.wg.Add(1)
-	go func() {
-		for {
-			select {
-			case <-w.closeS:
-				log.Info("sender stopped")
-				return
-			case <-time.After(1 * time.Second):
-				msgs, err := w.storage.SelectNew()
-				if err != nil {
-					log.Error(err)
-					continue
-				}
-
-				if len(msgs) == 0 {
-					time.Sleep(1 * time.Second)
-					continue
-				}
+// Run отправляет новые сообщения в брокер до отмены ctx. Пока Claim возвращает полную пачку,
+// следующая забирается сразу, пауза SendInterval — только после неполной или пустой пачки.
+// Начатая пачка дорабатывается до конца: отмена ctx не прерывает Send и Ack.
+func (w *Worker) Run(ctx context.Context) error {
+	work := context.WithoutCancel(ctx)
+	for {
+		for ctx.Err() == nil {
+			if w.send(work) < w.cfg.BatchSize {
+				break
+			}
+		}
 
-				if err = w.broker.Send(ctx, msgs); err != nil {
-					log.Error(err)
-					continue
-				}
+		select {
+		case <-ctx.Done():
+			log.Info("sender stopped")
+			return nil
+		case <-time.After(w.cfg.SendInterval):
+		}
+	}
+}
 
-				client := http.Client{}
-				request, err := http.NewRequest("PUT", "http://"+w.cfg.ServerAddr+"/api/messages/processing/add/"+strconv.Itoa(len(msgs)), nil)
-				if err == nil {
-					if _, err = client.Do(request); err != nil {
-						log.Error(err)
-					}
-				} else {
-					log.Error(err)
-				}
+// send отправляет одну пачку и возвращает количество отправленных сообщений.
+func (w *Worker) send(ctx context.Context) int {
+	claim, err := w.storage.Claim(ctx, w.cfg.BatchSize)
+	if err != nil {
+		log.Error(err)
+		return 0
+	}
 
-				request, err = http.NewRequest("PUT", "http://"+w.cfg.ServerAddr+"/api/messages/new/sub/"+strconv.Itoa(len(msgs)), nil)
-				if err == nil {
-					if _, err = client.Do(request); err != nil {
-						log.Error(err)
-					}
-				} else {
-					log.Error(err)
-				}
+	msgs := claim.Messages
+	if len(msgs) == 0 {
+		return 0
+	}
 
-				if err = w.storage.UpdateStatuses(msgs, model.Processing); err != nil {
-					log.Error(err)
-					continue
-				}
-			}
+	start := time.Now()
+	if err = w.broker.Send(ctx, msgs); err != nil {
+		log.Error(err)
+		if err = w.storage.Release(claim); err != nil {
+			log.Error(err)
 		}
-	}()
-}
+		return 0
+	}
+
+	if err = w.storage.Ack(claim); err != nil {
+		log.Error(err)
+		return 0
+	}
 
-func (w *Worker) Close() {
-	close(w.closeS)
-	w.wg.Wait()
+	w.prometheus.SentMessageCounter.Add(float64(len(msgs)))
+	w.prometheus.SendBatchSize.Observe(float64(len(msgs)))
+	w.prometheus.SendDuration.Observe(time.Since(start).Seconds())
+	return len(msgs)
 }
@@ -0,0 +1,51 @@
+package sender
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"messaggio/broker"
+	"messaggio/config"
+	"messaggio/model"
+	"messaggio/prometheus"
+	"messaggio/storage"
+)
+
+func TestRunFullBatches(t *testing.T) {
+	cfg := config.Default()
+	cfg.Kafka.BatchSize = 2
+	cfg.Kafka.SendInterval = time.Hour
+
+	mem := storage.NewMemory(cfg.DB)
+	msgs := make([]model.Message, 5)
+	for i := range msgs {
+		msgs[i] = model.Message{Content: "hello", From: "alice", To: "sink:test"}
+	}
+	if err := mem.InsertBatch(msgs, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	b := broker.NewMemory(cfg.Kafka)
+	defer b.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = New(b, mem, prometheus.New(mem), cfg.Kafka).Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Полные пачки забираются без паузы, поэтому все пять сообщений приходят задолго до SendInterval
+	recvCtx, cancelRecv := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelRecv()
+	for i := range msgs {
+		if _, err := b.Recv(recvCtx); err != nil {
+			t.Fatalf("recv %d: %v", i, err)
+		}
+	}
+}
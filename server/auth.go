@@ -0,0 +1,127 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"messaggio/auth"
+	"messaggio/model"
+	"messaggio/storage"
+)
+
+// authenticate определяет вызывающего по заголовку X-API-Key или Authorization: Bearer.
+// Bearer-токен из трёх частей через точку проверяется как JWT, остальные — как API-ключ.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return s.authenticateWith(next, false)
+}
+
+// authenticateStream дополнительно принимает билет из query-параметра ticket: браузерные
+// EventSource и WebSocket не передают заголовки. Билеты принимаются только потоками событий.
+func (s *Server) authenticateStream(next http.Handler) http.Handler {
+	return s.authenticateWith(next, true)
+}
+
+func (s *Server) authenticateWith(next http.Handler, allowTicket bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.cfg.Auth {
+			p := auth.Principal{Scopes: []string{model.ScopeAdmin}}
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+			return
+		}
+
+		token := r.Header.Get("X-API-Key")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token == "" {
+			token = strings.TrimSpace(bearer)
+		}
+		ticket := ""
+		if allowTicket && token == "" {
+			ticket = r.URL.Query().Get("ticket")
+		}
+		if token == "" && ticket == "" {
+			unauthorized(w, r, codeAPIKeyMissing)
+			return
+		}
+
+		var p auth.Principal
+		var err error
+		if ticket != "" {
+			p, err = auth.ParseTicket(ticket, s.ticketSecret, time.Now())
+		} else {
+			p, err = s.principal(token)
+		}
+		switch {
+		case errors.Is(err, storage.ErrNotFound), errors.Is(err, auth.ErrInvalidToken):
+			unauthorized(w, r, codeAPIKeyInvalid)
+			return
+		case errors.Is(err, auth.ErrExpiredToken):
+			unauthorized(w, r, codeTokenExpired)
+			return
+		case err != nil:
+			writeStorageError(w, r, err, codeAuthFailed)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+	})
+}
+
+func (s *Server) principal(token string) (auth.Principal, error) {
+	if s.cfg.AdminKey != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.AdminKey)) == 1 {
+		return auth.Principal{Owner: auth.AdminOwner, Scopes: []string{model.ScopeAdmin}}, nil
+	}
+
+	if auth.IsJWT(token) {
+		if s.cfg.JWTSecret == "" {
+			return auth.Principal{}, auth.ErrInvalidToken
+		}
+		return auth.ParseJWT(token, s.cfg.JWTSecret, time.Now())
+	}
+
+	key, err := s.storage.SelectAPIKey(auth.HashKey(token))
+	if err != nil {
+		return auth.Principal{}, err
+	}
+	return auth.Principal{Owner: auth.KeyOwner(key.Name), Scopes: key.Scopes, DailyQuota: key.DailyQuota}, nil
+}
+
+// require пропускает только вызывающих с областью scope.
+func require(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if p, _ := auth.FromContext(r.Context()); !p.Has(scope) {
+				writeError(w, r, http.StatusForbidden, codeForbidden, scope)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, code string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="messaggio"`)
+	writeError(w, r, http.StatusUnauthorized, code)
+}
+
+// owner возвращает владельца для новых сообщений вызывающего.
+func owner(r *http.Request) string {
+	p, _ := auth.FromContext(r.Context())
+	return p.Owner
+}
+
+// visible сообщает, может ли вызывающий видеть сообщение: без области admin — только свои.
+func visible(r *http.Request, msg model.Message) bool {
+	p, _ := auth.FromContext(r.Context())
+	return p.Admin() || msg.Owner == p.Owner
+}
+
+// ownerFilter возвращает владельца для фильтров выборки, пустой — все сообщения.
+func ownerFilter(r *http.Request) string {
+	p, _ := auth.FromContext(r.Context())
+	if p.Admin() {
+		return ""
+	}
+	return p.Owner
+}
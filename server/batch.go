@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"messaggio/model"
+	"messaggio/storage"
+)
+
+const maxBatchSize = 10000
+
+var errBatchTooLarge = errors.New("batch too large")
+
+type batchResult struct {
+	Index   int            `json:"index"`
+	Status  string         `json:"status"`
+	Message *model.Message `json:"message,omitempty"`
+	Code    string         `json:"code,omitempty"`
+	Text    string         `json:"text,omitempty"`
+	Errors  []fieldError   `json:"errors,omitempty"`
+}
+
+type responseBatch struct {
+	Status  string        `json:"status"`
+	Created int           `json:"created"`
+	Failed  int           `json:"failed"`
+	Results []batchResult `json:"results"`
+}
+
+func (r responseBatch) Write(w http.ResponseWriter, code int) {
+	w.WriteHeader(code)
+	marshal, _ := json.Marshal(r)
+	_, _ = w.Write(marshal)
+}
+
+// @Summary Create messages in bulk
+// @Description Create messages from a JSON array or an NDJSON stream (one request object per line).
+// @Description Valid items are inserted with a single query, invalid ones are reported per item.
+// @Accept  json
+// @Accept  application/x-ndjson
+// @Produce  json
+// @Param   messages  body  []request  true  "Messages"
+// @Success 201 {object} responseBatch
+// @Success 207 {object} responseBatch "Some items were rejected"
+// @Failure 400 {object} responseError
+// @Failure 413 {object} responseError
+// @Failure 500 {object} responseError
+// @Failure 503 {object} responseError
+// @Failure 401 {object} responseError
+// @Failure 403 {object} responseError
+// @Failure 429 {object} responseError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/messages/batch [post]
+func (s *Server) createMessages(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	body := bufio.NewReader(http.MaxBytesReader(w, r.Body, int64(s.cfg.MaxBatchBodySize)))
+	var (
+		results []batchResult
+		msgs    []model.Message
+		err     error
+	)
+	if isJSONArray(body) {
+		results, msgs, err = s.decodeArray(body)
+	} else {
+		results, msgs, err = s.decodeNDJSON(body)
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeError(w, r, http.StatusRequestEntityTooLarge, codeBodyTooLarge, maxBytesErr.Limit)
+		return
+	}
+	if err != nil {
+		code := codeInvalidBatch
+		if errors.Is(err, errBatchTooLarge) {
+			code = codeBatchTooLarge
+		}
+		writeError(w, r, http.StatusBadRequest, code)
+		return
+	}
+
+	if len(results) == 0 {
+		writeError(w, r, http.StatusBadRequest, codeBatchEmpty)
+		return
+	}
+
+	for i := range msgs {
+		msgs[i].Owner = owner(r)
+	}
+
+	if len(msgs) > 0 {
+		q := s.quota(r)
+		err = s.storage.InsertBatch(msgs, q)
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			s.quotaExceeded(w, r, q)
+			return
+		}
+		if err != nil {
+			writeStorageError(w, r, err, codeBatchCreateFailed)
+			return
+		}
+	}
+
+	lang := language(r)
+	w.Header().Set("Content-Language", lang)
+
+	response := responseBatch{Results: results}
+	var next int
+	for i := range response.Results {
+		if result := &response.Results[i]; result.Code != "" {
+			result.Text = localize(lang, result.Code)
+			result.Errors = localizeFields(lang, result.Errors)
+			response.Failed++
+			continue
+		}
+		response.Results[i].Message = &msgs[next]
+		response.Created++
+		next++
+	}
+
+	code := http.StatusCreated
+	switch {
+	case response.Created == 0:
+		code = http.StatusBadRequest
+	case response.Failed > 0:
+		code = http.StatusMultiStatus
+	}
+	response.Status = http.StatusText(code)
+	response.Write(w, code)
+}
+
+func isJSONArray(body *bufio.Reader) bool {
+	for {
+		b, err := body.Peek(1)
+		if err != nil {
+			return false
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(b[0])) {
+			return b[0] == '['
+		}
+		_, _ = body.ReadByte()
+	}
+}
+
+func (s *Server) decodeArray(body io.Reader) ([]batchResult, []model.Message, error) {
+	var items []json.RawMessage
+	if err := json.NewDecoder(body).Decode(&items); err != nil {
+		return nil, nil, err
+	}
+
+	if len(items) > maxBatchSize {
+		return nil, nil, errBatchTooLarge
+	}
+
+	var (
+		results = make([]batchResult, 0, len(items))
+		msgs    = make([]model.Message, 0, len(items))
+	)
+	for i, item := range items {
+		result, msg, ok := s.decodeItem(i, item)
+		results = append(results, result)
+		if ok {
+			msgs = append(msgs, msg)
+		}
+	}
+
+	return results, msgs, nil
+}
+
+func (s *Server) decodeNDJSON(body io.Reader) ([]batchResult, []model.Message, error) {
+	var (
+		results []batchResult
+		msgs    []model.Message
+	)
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if len(results) == maxBatchSize {
+			return nil, nil, errBatchTooLarge
+		}
+
+		result, msg, ok := s.decodeItem(len(results), line)
+		results = append(results, result)
+		if ok {
+			msgs = append(msgs, msg)
+		}
+	}
+
+	return results, msgs, scanner.Err()
+}
+
+func (s *Server) decodeItem(index int, data []byte) (batchResult, model.Message, bool) {
+	result := batchResult{
+		Index:  index,
+		Status: http.StatusText(http.StatusCreated),
+	}
+
+	var reqMsg request
+	if err := json.Unmarshal(data, &reqMsg); err != nil {
+		result.Status = http.StatusText(http.StatusBadRequest)
+		result.Code = codeInvalidMessage
+		return result, model.Message{}, false
+	}
+
+	if reqMsg.ClientID != "" {
+		result.Status = http.StatusText(http.StatusBadRequest)
+		result.Code = codeClientIDInBatch
+		return result, model.Message{}, false
+	}
+
+	if errs := s.validate(reqMsg); len(errs) > 0 {
+		result.Status = http.StatusText(http.StatusBadRequest)
+		result.Code = codeValidationFailed
+		result.Errors = errs
+		return result, model.Message{}, false
+	}
+
+	return result, model.Message{
+		Content:     reqMsg.Content,
+		From:        reqMsg.From,
+		To:          reqMsg.To,
+		CallbackURL: reqMsg.CallbackURL,
+		SendAt:      reqMsg.SendAt,
+		Priority:    reqMsg.Priority,
+		ExpiresAt:   reqMsg.expiresAt(time.Now()),
+		TTL:         reqMsg.TTL,
+	}, true
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"messaggio/storage"
+)
+
+const (
+	problemContentType = "application/problem+json"
+
+	// unavailableRetryAfter — через сколько предлагать повторить запрос при недоступной базе
+	unavailableRetryAfter = 5 * time.Second
+)
+
+// problem — ответ об ошибке в формате RFC 7807. Отдаётся клиентам,
+// которые указали application/problem+json в Accept.
+type problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []fieldError `json:"errors,omitempty"`
+}
+
+// writeError отвечает ошибкой code с текстом на языке из Accept-Language.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, args ...interface{}) {
+	writeFieldErrors(w, r, status, code, nil, args...)
+}
+
+// writeFieldErrors отвечает ошибкой code с ошибками отдельных полей.
+func writeFieldErrors(w http.ResponseWriter, r *http.Request, status int, code string, fields []fieldError, args ...interface{}) {
+	lang := language(r)
+	fields = localizeFields(lang, fields)
+	w.Header().Set("Content-Language", lang)
+
+	if wantsProblem(r) {
+		w.Header().Set("Content-Type", problemContentType)
+		w.WriteHeader(status)
+		marshal, _ := json.Marshal(problem{
+			Type:     "urn:messaggio:error:" + code,
+			Title:    http.StatusText(status),
+			Status:   status,
+			Detail:   localize(lang, code, args...),
+			Instance: r.URL.Path,
+			Code:     code,
+			Errors:   fields,
+		})
+		_, _ = w.Write(marshal)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	responseError{
+		Status: http.StatusText(status),
+		Code:   code,
+		Text:   localize(lang, code, args...),
+		Errors: fields,
+	}.Write(w, status)
+}
+
+// writeStorageError отвечает на ошибку storage одинаково во всех обработчиках: ErrNotFound — 404,
+// ErrConflict — 409, ErrUnavailable — 503 с Retry-After, остальные — 500 с кодом code.
+func writeStorageError(w http.ResponseWriter, r *http.Request, err error, code string) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, codeMessageNotFound)
+	case errors.Is(err, storage.ErrNotScheduled):
+		writeError(w, r, http.StatusConflict, codeMessageNotScheduled)
+	case errors.Is(err, storage.ErrConflict):
+		writeError(w, r, http.StatusConflict, codeConflict)
+	case errors.Is(err, storage.ErrUnavailable):
+		log.Warn(err)
+		w.Header().Set("Retry-After", strconv.Itoa(int(unavailableRetryAfter.Seconds())))
+		writeError(w, r, http.StatusServiceUnavailable, codeStorageUnavailable)
+	default:
+		log.Error(err)
+		writeError(w, r, http.StatusInternalServerError, code)
+	}
+}
+
+// localizeFields возвращает копию fields с текстами на языке lang.
+func localizeFields(lang string, fields []fieldError) []fieldError {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	localized := make([]fieldError, len(fields))
+	for i, f := range fields {
+		f.Text = localize(lang, f.Code, f.args...)
+		localized[i] = f
+	}
+	return localized
+}
+
+// wantsProblem сообщает, что клиент предпочитает problem+json обычному JSON.
+func wantsProblem(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == problemContentType && params["q"] != "0" {
+			return true
+		}
+	}
+	return false
+}
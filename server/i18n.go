@@ -0,0 +1,235 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	langRu = "ru"
+	langEn = "en"
+
+	// defaultLang — язык ответов без Accept-Language, как до появления каталога
+	defaultLang = langRu
+)
+
+// Коды ошибок API. Коды стабильны, клиенты могут на них полагаться, тексты могут меняться.
+const (
+	codeInvalidBody          = "INVALID_BODY"
+	codeBodyTooLarge         = "BODY_TOO_LARGE"
+	codeInvalidMessage       = "INVALID_MESSAGE"
+	codeValidationFailed     = "VALIDATION_FAILED"
+	codeIdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED"
+	codeInvalidBatch         = "INVALID_BATCH"
+	codeBatchTooLarge        = "BATCH_TOO_LARGE"
+	codeBatchEmpty           = "BATCH_EMPTY"
+	codeClientIDInBatch      = "CLIENT_ID_NOT_SUPPORTED"
+	codeInvalidStatus        = "INVALID_STATUS"
+	codeInvalidOrder         = "INVALID_ORDER"
+	codeInvalidCursor        = "INVALID_CURSOR"
+	codeInvalidLimit         = "INVALID_LIMIT"
+	codeInvalidSince         = "INVALID_SINCE"
+	codeInvalidUntil         = "INVALID_UNTIL"
+	codeMissingMessageID     = "MISSING_MESSAGE_ID"
+	codeInvalidMessageID     = "INVALID_MESSAGE_ID"
+	codeMessageNotFound      = "MESSAGE_NOT_FOUND"
+	codeMessageNotScheduled  = "MESSAGE_NOT_SCHEDULED"
+	codeStreamingUnsupported = "STREAMING_UNSUPPORTED"
+	codeAPIKeyMissing        = "API_KEY_MISSING"
+	codeAPIKeyInvalid        = "API_KEY_INVALID"
+	codeTokenExpired         = "TOKEN_EXPIRED"
+	codeForbidden            = "FORBIDDEN"
+	codeOriginForbidden      = "ORIGIN_FORBIDDEN"
+	codeRateLimited          = "RATE_LIMITED"
+	codeQuotaExceeded        = "QUOTA_EXCEEDED"
+	codeConflict             = "CONFLICT"
+	codeStorageUnavailable   = "STORAGE_UNAVAILABLE"
+
+	codeCreateFailed      = "CREATE_FAILED"
+	codeBatchCreateFailed = "BATCH_CREATE_FAILED"
+	codeListFailed        = "LIST_FAILED"
+	codeGetFailed         = "GET_FAILED"
+	codeHistoryFailed     = "HISTORY_FAILED"
+	codeCallbacksFailed   = "CALLBACKS_FAILED"
+	codeCancelFailed      = "CANCEL_FAILED"
+	codeAuthFailed        = "AUTH_FAILED"
+)
+
+// Коды ошибок отдельных полей сообщения.
+const (
+	codeRequired           = "REQUIRED"
+	codeTooLong            = "TOO_LONG"
+	codeControlCharacters  = "CONTROL_CHARACTERS"
+	codeInvalidWebhookURL  = "INVALID_WEBHOOK_URL"
+	codeInvalidEmail       = "INVALID_EMAIL"
+	codeEmptyAddress       = "EMPTY_ADDRESS"
+	codeUnsupportedScheme  = "UNSUPPORTED_SCHEME"
+	codeInvalidCallbackURL = "INVALID_CALLBACK_URL"
+	codeInvalidSendAt      = "INVALID_SEND_AT"
+	codeInvalidPriority    = "INVALID_PRIORITY"
+	codeInvalidTTL         = "INVALID_TTL"
+)
+
+// catalog — тексты ошибок по языку и коду, аргументы подставляются через fmt.
+var catalog = map[string]map[string]string{
+	langRu: {
+		codeInvalidBody:          "произошла ошибка при чтении тела запроса",
+		codeBodyTooLarge:         "тело запроса больше %d байт",
+		codeInvalidMessage:       "неверный формат сообщения",
+		codeValidationFailed:     "сообщение не прошло проверку",
+		codeIdempotencyKeyReused: "ключ идемпотентности уже использован для другого сообщения",
+		codeInvalidBatch:         "неверный формат пакета сообщений",
+		codeBatchTooLarge:        "слишком много сообщений в пакете",
+		codeBatchEmpty:           "пакет сообщений пуст",
+		codeClientIDInBatch:      "client_id не поддерживается при пакетной отправке",
+		codeInvalidStatus:        "неверный формат статуса",
+		codeInvalidOrder:         "неверный формат сортировки",
+		codeInvalidCursor:        "неверный формат курсора",
+		codeInvalidLimit:         "неверный формат лимита",
+		codeInvalidSince:         "неверный формат начала периода",
+		codeInvalidUntil:         "неверный формат конца периода",
+		codeMissingMessageID:     "не указан id сообщения",
+		codeInvalidMessageID:     "неверный формат id сообщения",
+		codeMessageNotFound:      "сообщение не найдено",
+		codeMessageNotScheduled:  "отменить можно только запланированное сообщение",
+		codeStreamingUnsupported: "потоковая передача не поддерживается",
+		codeAPIKeyMissing:        "не указан API-ключ",
+		codeAPIKeyInvalid:        "неверный API-ключ",
+		codeTokenExpired:         "срок действия токена истёк",
+		codeForbidden:            "недостаточно прав: требуется область %s",
+		codeOriginForbidden:      "подключение с этого Origin запрещено",
+		codeRateLimited:          "слишком много запросов",
+		codeQuotaExceeded:        "превышена суточная квота сообщений (%d)",
+		codeConflict:             "запрос конфликтует с текущим состоянием данных",
+		codeStorageUnavailable:   "хранилище временно недоступно, повторите запрос позже",
+
+		codeCreateFailed:      "произошла ошибка при добавлении сообщения",
+		codeBatchCreateFailed: "произошла ошибка при добавлении сообщений",
+		codeListFailed:        "произошла ошибка при получении сообщений",
+		codeGetFailed:         "произошла ошибка при получении сообщения",
+		codeHistoryFailed:     "произошла ошибка при получении истории сообщения",
+		codeCallbacksFailed:   "произошла ошибка при получении уведомлений сообщения",
+		codeCancelFailed:      "произошла ошибка при отмене сообщения",
+		codeAuthFailed:        "произошла ошибка при проверке API-ключа",
+
+		codeRequired:           "обязательное поле",
+		codeTooLong:            "не длиннее %d символов",
+		codeControlCharacters:  "не должно содержать управляющих символов",
+		codeInvalidWebhookURL:  "неверный адрес webhook",
+		codeInvalidEmail:       "неверный адрес email",
+		codeEmptyAddress:       "пустой адрес канала %s",
+		codeUnsupportedScheme:  "неподдерживаемая схема адреса, ожидается http(s):, mailto:, sink: или адрес без схемы",
+		codeInvalidCallbackURL: "ожидается абсолютный http(s) адрес вне внутренних сетей",
+		codeInvalidSendAt:      "ожидается unix-время",
+		codeInvalidPriority:    "допустимые значения: -1, 0, 1",
+		codeInvalidTTL:         "не может быть отрицательным",
+	},
+	langEn: {
+		codeInvalidBody:          "failed to read the request body",
+		codeBodyTooLarge:         "request body exceeds %d bytes",
+		codeInvalidMessage:       "malformed message",
+		codeValidationFailed:     "message validation failed",
+		codeIdempotencyKeyReused: "idempotency key is already used for another message",
+		codeInvalidBatch:         "malformed message batch",
+		codeBatchTooLarge:        "too many messages in the batch",
+		codeBatchEmpty:           "message batch is empty",
+		codeClientIDInBatch:      "client_id is not supported in batches",
+		codeInvalidStatus:        "invalid status",
+		codeInvalidOrder:         "invalid sort order",
+		codeInvalidCursor:        "invalid cursor",
+		codeInvalidLimit:         "invalid limit",
+		codeInvalidSince:         "invalid period start",
+		codeInvalidUntil:         "invalid period end",
+		codeMissingMessageID:     "message id is missing",
+		codeInvalidMessageID:     "invalid message id",
+		codeMessageNotFound:      "message not found",
+		codeMessageNotScheduled:  "only a scheduled message can be canceled",
+		codeStreamingUnsupported: "streaming is not supported",
+		codeAPIKeyMissing:        "API key is missing",
+		codeAPIKeyInvalid:        "invalid API key",
+		codeTokenExpired:         "token has expired",
+		codeForbidden:            "insufficient permissions: scope %s required",
+		codeOriginForbidden:      "connections from this origin are not allowed",
+		codeRateLimited:          "too many requests",
+		codeQuotaExceeded:        "daily message quota exceeded (%d)",
+		codeConflict:             "request conflicts with the current state of the data",
+		codeStorageUnavailable:   "storage is temporarily unavailable, retry later",
+
+		codeCreateFailed:      "failed to create the message",
+		codeBatchCreateFailed: "failed to create the messages",
+		codeListFailed:        "failed to get messages",
+		codeGetFailed:         "failed to get the message",
+		codeHistoryFailed:     "failed to get the message history",
+		codeCallbacksFailed:   "failed to get the message callbacks",
+		codeCancelFailed:      "failed to cancel the message",
+		codeAuthFailed:        "failed to check the API key",
+
+		codeRequired:           "required field",
+		codeTooLong:            "must be at most %d characters",
+		codeControlCharacters:  "must not contain control characters",
+		codeInvalidWebhookURL:  "invalid webhook URL",
+		codeInvalidEmail:       "invalid email address",
+		codeEmptyAddress:       "empty %s channel address",
+		codeUnsupportedScheme:  "unsupported address scheme, expected http(s):, mailto:, sink: or no scheme",
+		codeInvalidCallbackURL: "absolute http(s) URL outside internal networks expected",
+		codeInvalidSendAt:      "unix time expected",
+		codeInvalidPriority:    "allowed values: -1, 0, 1",
+		codeInvalidTTL:         "must not be negative",
+	},
+}
+
+// localize возвращает текст ошибки code на языке lang.
+func localize(lang, code string, args ...interface{}) string {
+	text, ok := catalog[lang][code]
+	if !ok {
+		text, ok = catalog[defaultLang][code]
+	}
+	if !ok {
+		return code
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// language выбирает язык из Accept-Language с учётом q-весов. Поддерживаются ru и en,
+// при их отсутствии в заголовке используется defaultLang.
+func language(r *http.Request) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, ok := catalog[lang]; !ok {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{lang, q})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return defaultLang
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	return candidates[0].lang
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"messaggio/auth"
+	"messaggio/storage"
+)
+
+// bucketIdle — через сколько неиспользуемая корзина удаляется.
+const bucketIdle = 10 * time.Minute
+
+type bucket struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+// rateLimiter — token bucket на каждого вызывающего в памяти процесса.
+// У каждого экземпляра server свои корзины, суммарный лимит растёт с числом экземпляров.
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   rate.Limit
+	burst   int
+	buckets map[string]*bucket
+	cleaned time.Time
+}
+
+func newRateLimiter(perSecond, burst int) *rateLimiter {
+	return &rateLimiter{
+		limit:   rate.Limit(perSecond),
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+		cleaned: time.Now(),
+	}
+}
+
+// reserve забирает токен из корзины key и возвращает 0 или время до появления следующего токена.
+func (l *rateLimiter) reserve(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.cleaned) > bucketIdle {
+		for k, b := range l.buckets {
+			if now.Sub(b.seen) > bucketIdle {
+				delete(l.buckets, k)
+			}
+		}
+		l.cleaned = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+	b.seen = now
+
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+// ipRateLimit ограничивает частоту запросов с одного IP до аутентификации, чтобы перебор ключей
+// и запросы с неверными ключами не доходили до хранилища без ограничения.
+func (s *Server) ipRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.ipLimiter != nil && !s.allow(w, r, s.ipLimiter, remoteIP(r), "ip_rate_limit") {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimit ограничивает частоту запросов по владельцу, а без аутентификации — по IP.
+func (s *Server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := "ip:" + remoteIP(r)
+		if o := owner(r); o != "" {
+			key = "owner:" + o
+		}
+		if !s.allow(w, r, s.limiter, key, "rate_limit") {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow забирает токен из корзины key, а если его нет — отвечает 429 и возвращает false.
+func (s *Server) allow(w http.ResponseWriter, r *http.Request, l *rateLimiter, key, reason string) bool {
+	delay := l.reserve(key, time.Now())
+	if delay == 0 {
+		return true
+	}
+
+	s.prometheus.RejectedRequestCounter.WithLabelValues(reason).Inc()
+	tooManyRequests(w, r, delay, codeRateLimited)
+	return false
+}
+
+// quota возвращает суточную квоту вызывающего, nil — квота не ограничена. Квота списывается
+// хранилищем только за действительно добавленные сообщения. Ключи с областью admin квотой не ограничиваются.
+func (s *Server) quota(r *http.Request) *storage.Quota {
+	p, _ := auth.FromContext(r.Context())
+	limit := s.cfg.DailyQuota
+	if p.DailyQuota > 0 {
+		limit = p.DailyQuota
+	}
+	if limit == 0 || p.Admin() {
+		return nil
+	}
+
+	return &storage.Quota{Owner: p.Owner, Day: time.Now().UTC(), Limit: limit}
+}
+
+// quotaExceeded отвечает 429 с Retry-After до начала следующих суток UTC.
+func (s *Server) quotaExceeded(w http.ResponseWriter, r *http.Request, q *storage.Quota) {
+	s.prometheus.RejectedRequestCounter.WithLabelValues("quota").Inc()
+	now := time.Now().UTC()
+	tomorrow := time.Date(q.Day.Year(), q.Day.Month(), q.Day.Day()+1, 0, 0, 0, 0, time.UTC)
+	tooManyRequests(w, r, tomorrow.Sub(now), codeQuotaExceeded, q.Limit)
+}
+
+func tooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration, code string, args ...interface{}) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeError(w, r, http.StatusTooManyRequests, code, args...)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
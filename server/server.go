@@ -2,11 +2,15 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"net/url"
 	"slices"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -15,89 +19,146 @@ import (
 	"messaggio/config"
 	_ "messaggio/docs"
 	"messaggio/model"
+	"messaggio/netguard"
 	"messaggio/prometheus"
 	"messaggio/storage"
 )
 
 type Server struct {
 	cfg        config.Server
-	storage    *storage.Storage
+	delivery   config.Delivery
+	storage    storage.Repository
 	server     *http.Server
 	prometheus *prometheus.Prometheus
+	hub        *hub
+	limiter    *rateLimiter
+	ipLimiter  *rateLimiter
+
+	// ticketSecret подписывает билеты потоков событий
+	ticketSecret []byte
 }
 
-func New(cfg config.Server, s *storage.Storage, p *prometheus.Prometheus) *Server {
-	return &Server{
+// New создаёт сервер API. Настройки доставки нужны для проверки адресов получателей.
+func New(cfg config.Server, d config.Delivery, s storage.Repository, p *prometheus.Prometheus) *Server {
+	srv := &Server{
 		cfg:        cfg,
+		delivery:   d,
 		storage:    s,
 		prometheus: p,
+		hub:        newHub(),
+	}
+	if cfg.RateLimit > 0 {
+		srv.limiter = newRateLimiter(cfg.RateLimit, cfg.RateBurst)
 	}
+	if cfg.IPRateLimit > 0 {
+		srv.ipLimiter = newRateLimiter(cfg.IPRateLimit, cfg.IPRateBurst)
+	}
+
+	srv.ticketSecret = []byte(cfg.TicketSecret)
+	if cfg.TicketSecret == "" {
+		srv.ticketSecret = make([]byte, 32)
+		if _, err := rand.Read(srv.ticketSecret); err != nil {
+			panic(err)
+		}
+	}
+	return srv
 }
 
-func (s *Server) Start() {
+// Run обслуживает HTTP до отмены ctx. После отмены закрывает потоки событий и ждёт
+// завершения активных запросов не дольше cfg.ShutdownTimeout.
+func (s *Server) Run(ctx context.Context) error {
+	listenCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go s.listen(listenCtx)
+
+	s.server = &http.Server{
+		Addr:         s.cfg.Http,
+		Handler:      s.routes(),
+		ReadTimeout:  s.cfg.ReadTimeout,
+		WriteTimeout: s.cfg.WriteTimeout,
+		IdleTimeout:  s.cfg.IdleTimeout,
+	}
+
+	errCh := make(chan error, 1)
 	go func() {
-		r := chi.NewRouter()
+		log.Infof("http server starting on %s", s.cfg.Http)
+		errCh <- s.server.ListenAndServe()
+	}()
 
-		r.Handle("/metrics", promhttp.Handler())
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
 
-		r.Get("/api/swagger/*", httpSwagger.WrapHandler)
+	s.hub.close()
 
-		r.Put("/api/messages/ok/add/{num}", s.AddOk)
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.WithoutCancel(ctx), s.cfg.ShutdownTimeout)
+	defer cancelShutdown()
+	if err := s.server.Shutdown(shutdownCtx); err != nil {
+		_ = s.server.Close()
+		return err
+	}
 
-		r.Put("/api/messages/new/add/{num}", s.AddNew)
-		r.Put("/api/messages/new/sub/{num}", s.SubNew)
+	log.Info("http server stopped")
+	return nil
+}
 
-		r.Put("/api/messages/error/add/{num}", s.AddError)
+func (s *Server) routes() http.Handler {
+	r := chi.NewRouter()
 
-		r.Put("/api/messages/processing/add/{num}", s.AddProcessing)
-		r.Put("/api/messages/processing/sub/{num}", s.SubProcessing)
+	r.Get("/api/swagger/*", httpSwagger.WrapHandler)
 
-		r.Post("/api/messages", s.createMessage)
-		r.Get("/api/messages", s.getMessages)
-		r.Get("/api/messages/{id}", s.getMessage)
+	r.Group(func(r chi.Router) {
+		r.Use(s.ipRateLimit)
+		r.Use(s.authenticate)
+		r.Use(s.rateLimit)
 
-		s.server = &http.Server{
-			Addr:    s.cfg.Http,
-			Handler: r,
-		}
+		// Метрики и их изменение доступны только администратору
+		r.Group(func(r chi.Router) {
+			r.Use(require(model.ScopeAdmin))
 
-		log.Infof("http server starting on %s", s.cfg.Http)
-		if err := s.server.ListenAndServe(); err != nil {
-			log.Error(err)
-		}
-		log.Info("http server stopped")
-	}()
-}
+			r.Handle("/metrics", promhttp.Handler())
 
-func (s *Server) Close(ctx context.Context) {
-	_ = s.server.Shutdown(ctx)
-}
+			r.Put("/api/messages/ok/add/{num}", s.AddOk)
+			r.Put("/api/messages/error/add/{num}", s.AddError)
+		})
 
-func (s *Server) AddOk(_ http.ResponseWriter, r *http.Request) {
-	num, err := strconv.Atoi(chi.URLParam(r, "num"))
-	if err != nil {
-		return
-	}
+		r.Group(func(r chi.Router) {
+			r.Use(require(model.ScopeMessages))
 
-	s.prometheus.OkMessageCounter.Add(float64(num))
-}
+			r.Post("/api/messages", s.createMessage)
+			r.Post("/api/messages/batch", s.createMessages)
+			r.Get("/api/messages", s.getMessages)
+			r.Post("/api/messages/stream/ticket", s.createTicket)
+			r.Get("/api/messages/{id}", s.getMessage)
+			r.Delete("/api/messages/{id}", s.cancelMessage)
+			r.Get("/api/messages/{id}/history", s.getHistory)
+			r.Get("/api/messages/{id}/callbacks", s.getCallbacks)
+		})
+	})
 
-func (s *Server) AddNew(_ http.ResponseWriter, r *http.Request) {
-	num, err := strconv.Atoi(chi.URLParam(r, "num"))
-	if err != nil {
-		return
-	}
+	// Потоки событий принимают ещё и билет из query-параметра
+	r.Group(func(r chi.Router) {
+		r.Use(s.ipRateLimit)
+		r.Use(s.authenticateStream)
+		r.Use(s.rateLimit)
+		r.Use(require(model.ScopeMessages))
+
+		r.Get("/api/messages/stream", s.streamEvents)
+		r.Get("/api/messages/stream/ws", s.streamEventsWS)
+	})
 
-	s.prometheus.NewMessageGauge.Add(float64(num))
+	return r
 }
 
-func (s *Server) SubNew(_ http.ResponseWriter, r *http.Request) {
+func (s *Server) AddOk(_ http.ResponseWriter, r *http.Request) {
 	num, err := strconv.Atoi(chi.URLParam(r, "num"))
 	if err != nil {
 		return
 	}
 
-	s.prometheus.NewMessageGauge.Sub(float64(num))
+	s.prometheus.OkMessageCounter.Add(float64(num))
 }
 
 func (s *Server) AddError(_ http.ResponseWriter, r *http.Request) {
@@ -109,33 +170,10 @@ func (s *Server) AddError(_ http.ResponseWriter, r *http.Request) {
 	s.prometheus.ErrorMessageCounter.Add(float64(num))
 }
 
-func (s *Server) AddProcessing(_ http.ResponseWriter, r *http.Request) {
-	num, err := strconv.Atoi(chi.URLParam(r, "num"))
-	if err != nil {
-		return
-	}
-
-	s.prometheus.ProcessingMessageGauge.Add(float64(num))
-}
-
-func (s *Server) SubProcessing(_ http.ResponseWriter, r *http.Request) {
-	num, err := strconv.Atoi(chi.URLParam(r, "num"))
-	if err != nil {
-		return
-	}
-
-	s.prometheus.ProcessingMessageGauge.Sub(float64(num))
-}
-
-// @title Messaggio API
-// @version 1.0
-// @description This is a simple message broker
-// @host localhost:8080
-// @BasePath /api
-
 type responseMessages struct {
-	Status   string          `json:"status"`
-	Messages []model.Message `json:"messages"`
+	Status     string          `json:"status"`
+	Messages   []model.Message `json:"messages"`
+	NextCursor int             `json:"next_cursor,omitempty"`
 }
 
 func (r responseMessages) Write(w http.ResponseWriter, code int) {
@@ -155,9 +193,33 @@ func (r responseMessage) Write(w http.ResponseWriter, code int) {
 	_, _ = w.Write(marshal)
 }
 
+type responseHistory struct {
+	Status string        `json:"status"`
+	Events []model.Event `json:"events"`
+}
+
+func (r responseHistory) Write(w http.ResponseWriter, code int) {
+	w.WriteHeader(code)
+	marshal, _ := json.Marshal(r)
+	_, _ = w.Write(marshal)
+}
+
+type responseCallbacks struct {
+	Status   string                  `json:"status"`
+	Attempts []model.CallbackAttempt `json:"attempts"`
+}
+
+func (r responseCallbacks) Write(w http.ResponseWriter, code int) {
+	w.WriteHeader(code)
+	marshal, _ := json.Marshal(r)
+	_, _ = w.Write(marshal)
+}
+
 type responseError struct {
-	Status string `json:"status"`
-	Text   string `json:"text"`
+	Status string       `json:"status"`
+	Code   string       `json:"code"`
+	Text   string       `json:"text"`
+	Errors []fieldError `json:"errors,omitempty"`
 }
 
 func (r responseError) Write(w http.ResponseWriter, code int) {
@@ -167,56 +229,141 @@ func (r responseError) Write(w http.ResponseWriter, code int) {
 }
 
 type request struct {
-	Content string `json:"content"`
-	From    string `json:"from"`
-	To      string `json:"to"`
+	Content  string `json:"content"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	ClientID string `json:"client_id,omitempty"`
+
+	// CallbackURL получает POST-уведомление, когда сообщение перейдёт в ok или error
+	CallbackURL string `json:"callback_url,omitempty"`
+
+	// SendAt откладывает отправку до указанного unix-времени
+	SendAt int64 `json:"send_at,omitempty"`
+
+	// Priority: -1 — низкий, 0 — обычный, 1 — высокий
+	Priority int `json:"priority,omitempty" enums:"-1,0,1"`
+
+	// TTL — срок жизни в секундах от send_at или от создания, после него недоставленное сообщение переходит в expired
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// expiresAt возвращает время истечения срока жизни сообщения, 0 — без срока.
+func (r request) expiresAt(now time.Time) int64 {
+	if r.TTL == 0 {
+		return 0
+	}
+	return max(now.Unix(), r.SendAt) + r.TTL
+}
+
+func validPriority(priority int) bool {
+	return priority >= model.PriorityLow && priority <= model.PriorityHigh
+}
+
+// validCallbackURL допускает только абсолютные http(s) адреса. Внутренние адреса, заданные явно,
+// отклоняются сразу, имена проверяются dispatcher'ом при соединении.
+func validCallbackURL(raw string) bool {
+	if raw == "" {
+		return true
+	}
+
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && !netguard.ForbiddenHost(u.Hostname())
 }
 
 // @Summary Create a new message
-// @Description Create a new message
+// @Description Create a new message. A retry with the same Idempotency-Key header (or client_id field)
+// @Description returns the originally created message instead of inserting a duplicate.
 // @Accept  json
 // @Produce  json
+// @Param   Idempotency-Key  header  string  false  "Idempotency key, overrides client_id"
 // @Param   message  body  request  true  "Message content"
+// @Success 200 {object} responseMessage "Message with this idempotency key already exists"
 // @Success 201 {object} responseMessage
 // @Failure 400 {object} responseError
+// @Failure 409 {object} responseError
+// @Failure 413 {object} responseError
 // @Failure 500 {object} responseError
+// @Failure 503 {object} responseError
+// @Failure 401 {object} responseError
+// @Failure 403 {object} responseError
+// @Failure 429 {object} responseError
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/messages [post]
 func (s *Server) createMessage(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	body, err := io.ReadAll(r.Body)
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(s.cfg.MaxBodySize)))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeError(w, r, http.StatusRequestEntityTooLarge, codeBodyTooLarge, maxBytesErr.Limit)
+		return
+	}
 	if err != nil {
-		responseError{
-			Status: http.StatusText(http.StatusBadRequest),
-			Text:   "произошла ошибка при чтении тела запроса",
-		}.Write(w, http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeInvalidBody)
 		return
 	}
 
 	var reqMsg request
 	if err = json.Unmarshal(body, &reqMsg); err != nil {
-		responseError{
-			Status: http.StatusText(http.StatusBadRequest),
-			Text:   "неверный формат сообщения",
-		}.Write(w, http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeInvalidMessage)
+		return
+	}
+
+	errs := s.validate(reqMsg)
+	// Заголовок заменяет client_id, поэтому ограничен той же длиной
+	if len(r.Header.Get("Idempotency-Key")) > maxClientIDLength {
+		errs = append(errs, fieldError{Field: "idempotency_key", Code: codeTooLong, args: []interface{}{maxClientIDLength}})
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, r, http.StatusBadRequest, codeValidationFailed, errs)
 		return
 	}
 
 	var msg = model.Message{
-		Content: reqMsg.Content,
-		From:    reqMsg.From,
-		To:      reqMsg.To,
+		Content:        reqMsg.Content,
+		From:           reqMsg.From,
+		To:             reqMsg.To,
+		IdempotencyKey: reqMsg.ClientID,
+		CallbackURL:    reqMsg.CallbackURL,
+		SendAt:         reqMsg.SendAt,
+		Priority:       reqMsg.Priority,
+		ExpiresAt:      reqMsg.expiresAt(time.Now()),
+		TTL:            reqMsg.TTL,
+		Owner:          owner(r),
+	}
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		msg.IdempotencyKey = key
+	}
+
+	q := s.quota(r)
+	created := true
+	if msg.IdempotencyKey == "" {
+		err = s.storage.Insert(&msg, q)
+	} else {
+		created, err = s.storage.InsertOnce(&msg, s.cfg.IdempotencyRetention, q)
+	}
+	if errors.Is(err, storage.ErrQuotaExceeded) {
+		s.quotaExceeded(w, r, q)
+		return
 	}
-	if err = s.storage.Insert(&msg); err != nil {
-		log.Error(err)
-		responseError{
-			Status: http.StatusText(http.StatusInternalServerError),
-			Text:   "произошла ошибка при добавлении сообщения",
-		}.Write(w, http.StatusInternalServerError)
+	if err != nil {
+		writeStorageError(w, r, err, codeCreateFailed)
 		return
 	}
 
-	s.prometheus.NewMessageGauge.Inc()
+	if !created {
+		if msg.Content != reqMsg.Content || msg.From != reqMsg.From || msg.To != reqMsg.To || msg.CallbackURL != reqMsg.CallbackURL || msg.SendAt != reqMsg.SendAt || msg.Priority != reqMsg.Priority || msg.TTL != reqMsg.TTL {
+			writeError(w, r, http.StatusConflict, codeIdempotencyKeyReused)
+			return
+		}
+
+		responseMessage{
+			Status:  http.StatusText(http.StatusOK),
+			Message: msg,
+		}.Write(w, http.StatusOK)
+		return
+	}
 
 	responseMessage{
 		Status:  http.StatusText(http.StatusCreated),
@@ -225,69 +372,100 @@ func (s *Server) createMessage(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary Get all messages
-// @Description Get all messages
+// @Description Get messages with keyset pagination: pass next_cursor from the previous response as after_id
 // @Produce  json
-// @Param status query string false "Status filter" Enums(new, processing, ok, error)
-// @Param page query int false "Page number" default(1)
-// @Param limit query int false "Page size" default(50)
+// @Param status query string false "Status filter" Enums(new, scheduled, processing, ok, error, canceled, expired)
+// @Param from query string false "Sender filter"
+// @Param to query string false "Recipient filter"
+// @Param content query string false "Content substring filter (case-insensitive)"
+// @Param since query int false "Created at or after, unix timestamp"
+// @Param until query int false "Created at or before, unix timestamp"
+// @Param order query string false "Sort order by id" Enums(asc, desc) default(asc)
+// @Param after_id query int false "Cursor: return messages after this id in the chosen order"
+// @Param limit query int false "Page size" default(50) minimum(1) maximum(1000)
 // @Success 200 {object} responseMessages
 // @Failure 400 {object} responseError
 // @Failure 500 {object} responseError
+// @Failure 503 {object} responseError
+// @Failure 401 {object} responseError
+// @Failure 403 {object} responseError
+// @Failure 429 {object} responseError
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/messages [get]
 func (s *Server) getMessages(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	status := r.URL.Query().Get("status")
-	if !slices.Contains([]string{"", model.Ok.String(), model.New.String(), model.Processing.String(), model.Error.String()}, status) {
-		responseError{
-			Status: http.StatusText(http.StatusBadRequest),
-			Text:   "неверный формат статуса",
-		}.Write(w, http.StatusBadRequest)
+	query := r.URL.Query()
+	filter := storage.Filter{
+		Owner:   ownerFilter(r),
+		Status:  query.Get("status"),
+		From:    query.Get("from"),
+		To:      query.Get("to"),
+		Content: query.Get("content"),
+	}
+
+	statuses := []model.Status{"", model.New, model.Scheduled, model.Processing, model.Ok, model.Error, model.Canceled, model.Expired}
+	if !slices.Contains(statuses, model.Status(filter.Status)) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidStatus)
 		return
 	}
 
-	page := r.URL.Query().Get("page")
-	if page == "" {
-		page = "1"
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		writeError(w, r, http.StatusBadRequest, codeInvalidOrder)
+		return
 	}
 
-	limit := r.URL.Query().Get("limit")
-	if limit == "" {
-		limit = "50"
+	var err error
+	if filter.AfterID, err = queryInt(query, "after_id", 0); err != nil || filter.AfterID < 0 {
+		writeError(w, r, http.StatusBadRequest, codeInvalidCursor)
+		return
 	}
 
-	intPage, err := strconv.Atoi(page)
-	if err != nil {
-		responseError{
-			Status: http.StatusText(http.StatusBadRequest),
-			Text:   "неверный формат страницы",
-		}.Write(w, http.StatusBadRequest)
+	if filter.Limit, err = queryInt(query, "limit", s.cfg.DefaultLimit); err != nil || filter.Limit < 1 || filter.Limit > s.cfg.MaxLimit {
+		writeError(w, r, http.StatusBadRequest, codeInvalidLimit)
 		return
 	}
 
-	intLimit, err := strconv.Atoi(limit)
-	if err != nil {
-		responseError{
-			Status: http.StatusText(http.StatusBadRequest),
-			Text:   "неверный формат лимита",
-		}.Write(w, http.StatusBadRequest)
+	since, err := queryInt(query, "since", 0)
+	if err != nil || since < 0 {
+		writeError(w, r, http.StatusBadRequest, codeInvalidSince)
 		return
 	}
 
-	msgs, err := s.storage.SelectAll(status, intPage, intLimit)
+	until, err := queryInt(query, "until", 0)
+	if err != nil || until < 0 || (until != 0 && until < since) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidUntil)
+		return
+	}
+	filter.Since, filter.Until = int64(since), int64(until)
+
+	msgs, err := s.storage.SelectAll(filter)
 	if err != nil {
-		log.Error(err)
-		responseError{
-			Status: http.StatusText(http.StatusInternalServerError),
-			Text:   "произошла ошибка при получении сообщений",
-		}.Write(w, http.StatusInternalServerError)
+		writeStorageError(w, r, err, codeListFailed)
 		return
 	}
 
-	responseMessages{
+	response := responseMessages{
 		Status:   http.StatusText(http.StatusOK),
 		Messages: msgs,
-	}.Write(w, http.StatusOK)
+	}
+	if len(msgs) == filter.Limit {
+		response.NextCursor = msgs[len(msgs)-1].ID
+	}
+	response.Write(w, http.StatusOK)
+}
+
+func queryInt(query url.Values, key string, def int) (int, error) {
+	value := query.Get(key)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
 }
 
 // @Summary Get a message by ID
@@ -296,36 +474,185 @@ func (s *Server) getMessages(w http.ResponseWriter, r *http.Request) {
 // @Param   id  path  string  true  "Message ID"
 // @Success 200 {object} responseMessage
 // @Failure 400 {object} responseError
+// @Failure 404 {object} responseError
 // @Failure 500 {object} responseError
+// @Failure 503 {object} responseError
+// @Failure 401 {object} responseError
+// @Failure 403 {object} responseError
+// @Failure 429 {object} responseError
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/messages/{id} [get]
 func (s *Server) getMessage(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	id := chi.URLParam(r, "id")
 	if id == "" {
-		responseError{
-			Status: http.StatusText(http.StatusBadRequest),
-			Text:   "не указан id сообщения",
-		}.Write(w, http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeMissingMessageID)
+		return
+	}
+
+	intId, err := strconv.Atoi(id)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidMessageID)
+		return
+	}
+
+	msg, err := s.storage.SelectById(intId)
+	if err != nil {
+		writeStorageError(w, r, err, codeGetFailed)
+		return
+	}
+
+	if !visible(r, msg) {
+		writeError(w, r, http.StatusNotFound, codeMessageNotFound)
+		return
+	}
+
+	responseMessage{
+		Status:  http.StatusText(http.StatusOK),
+		Message: msg,
+	}.Write(w, http.StatusOK)
+}
+
+// @Summary Get message status history
+// @Description Get status transitions of a message with timestamps, worker instance and error text
+// @Produce  json
+// @Param   id  path  string  true  "Message ID"
+// @Success 200 {object} responseHistory
+// @Failure 400 {object} responseError
+// @Failure 404 {object} responseError
+// @Failure 500 {object} responseError
+// @Failure 503 {object} responseError
+// @Failure 401 {object} responseError
+// @Failure 403 {object} responseError
+// @Failure 429 {object} responseError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/messages/{id}/history [get]
+func (s *Server) getHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		writeError(w, r, http.StatusBadRequest, codeMissingMessageID)
 		return
 	}
 
 	intId, err := strconv.Atoi(id)
 	if err != nil {
-		responseError{
-			Status: http.StatusText(http.StatusBadRequest),
-			Text:   "неверный формат id сообщения",
-		}.Write(w, http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeInvalidMessageID)
+		return
+	}
+
+	msg, err := s.storage.SelectById(intId)
+	if err != nil {
+		writeStorageError(w, r, err, codeGetFailed)
+		return
+	}
+
+	if !visible(r, msg) {
+		writeError(w, r, http.StatusNotFound, codeMessageNotFound)
+		return
+	}
+
+	events, err := s.storage.SelectEvents(intId)
+	if err != nil {
+		writeStorageError(w, r, err, codeHistoryFailed)
+		return
+	}
+
+	responseHistory{
+		Status: http.StatusText(http.StatusOK),
+		Events: events,
+	}.Write(w, http.StatusOK)
+}
+
+// @Summary Get callback attempts of a message
+// @Description Get every attempt to notify callback_url of the message: response code, error and resulting state
+// @Produce  json
+// @Param   id  path  string  true  "Message ID"
+// @Success 200 {object} responseCallbacks
+// @Failure 400 {object} responseError
+// @Failure 404 {object} responseError
+// @Failure 500 {object} responseError
+// @Failure 503 {object} responseError
+// @Failure 401 {object} responseError
+// @Failure 403 {object} responseError
+// @Failure 429 {object} responseError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/messages/{id}/callbacks [get]
+func (s *Server) getCallbacks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	intId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidMessageID)
+		return
+	}
+
+	msg, err := s.storage.SelectById(intId)
+	if err != nil {
+		writeStorageError(w, r, err, codeGetFailed)
+		return
+	}
+
+	if !visible(r, msg) {
+		writeError(w, r, http.StatusNotFound, codeMessageNotFound)
+		return
+	}
+
+	attempts, err := s.storage.SelectCallbackAttempts(intId)
+	if err != nil {
+		writeStorageError(w, r, err, codeCallbacksFailed)
+		return
+	}
+
+	responseCallbacks{
+		Status:   http.StatusText(http.StatusOK),
+		Attempts: attempts,
+	}.Write(w, http.StatusOK)
+}
+
+// @Summary Cancel a scheduled message
+// @Description Cancel a message waiting for its send_at. Messages already picked up for sending cannot be canceled.
+// @Produce  json
+// @Param   id  path  string  true  "Message ID"
+// @Success 200 {object} responseMessage
+// @Failure 400 {object} responseError
+// @Failure 404 {object} responseError
+// @Failure 409 {object} responseError
+// @Failure 500 {object} responseError
+// @Failure 503 {object} responseError
+// @Failure 401 {object} responseError
+// @Failure 403 {object} responseError
+// @Failure 429 {object} responseError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/messages/{id} [delete]
+func (s *Server) cancelMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	intId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidMessageID)
 		return
 	}
 
+	// Чужое сообщение выглядит для вызывающего как несуществующее
 	msg, err := s.storage.SelectById(intId)
 	if err != nil {
-		log.Error(err)
-		responseError{
-			Status: http.StatusText(http.StatusInternalServerError),
-			Text:   "произошла ошибка при получении сообщения",
-		}.Write(w, http.StatusInternalServerError)
+		writeStorageError(w, r, err, codeGetFailed)
+		return
+	}
+	if !visible(r, msg) {
+		writeError(w, r, http.StatusNotFound, codeMessageNotFound)
+		return
+	}
+
+	if msg, err = s.storage.Cancel(intId); err != nil {
+		writeStorageError(w, r, err, codeCancelFailed)
 		return
 	}
 
@@ -0,0 +1,520 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"messaggio/auth"
+	"messaggio/config"
+	"messaggio/model"
+	"messaggio/prometheus"
+	"messaggio/storage"
+)
+
+const (
+	testAdminKey = "admin-key-0123456789"
+	testSecret   = "jwt-secret-0123456789-0123456789"
+	testTicket   = "ticket-secret-0123456789-01234567"
+	testOrigin   = "https://app.example.com"
+	aliceKey     = "msg_alice"
+	bobKey       = "msg_bob"
+)
+
+// Метрики регистрируются глобально, поэтому экземпляр один на все тесты
+var testPrometheus = prometheus.New(storage.NewMemory(config.DataBase{}))
+
+// failingStorage отвечает ошибкой err на все вызовы, которые используют обработчики сообщений
+type failingStorage struct {
+	storage.Repository
+	err error
+}
+
+func (f failingStorage) Insert(*model.Message, *storage.Quota) error { return f.err }
+
+func (f failingStorage) SelectAll(storage.Filter) ([]model.Message, error) { return nil, f.err }
+
+func (f failingStorage) SelectById(int) (model.Message, error) { return model.Message{}, f.err }
+
+func (f failingStorage) SelectEvents(int) ([]model.Event, error) { return nil, f.err }
+
+func (f failingStorage) Cancel(int) (model.Message, error) { return model.Message{}, f.err }
+
+// newTestStorage возвращает хранилище с ключами alice и bob и сообщениями:
+// 1 — запланированное сообщение alice, 2 — новое сообщение alice, 3 — сообщение bob.
+func newTestStorage(t *testing.T) *storage.Memory {
+	t.Helper()
+
+	store := storage.NewMemory(config.DataBase{})
+	for owner, key := range map[string]string{"alice": aliceKey, "bob": bobKey} {
+		err := store.CreateAPIKey(&model.APIKey{Name: owner, KeyHash: auth.HashKey(key), Scopes: []string{model.ScopeMessages}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msgs := []model.Message{
+		{Content: "hello", From: "alice", To: "a@example.com", Owner: auth.KeyOwner("alice"), SendAt: time.Now().Add(time.Hour).Unix()},
+		{Content: "hello", From: "alice", To: "b@example.com", Owner: auth.KeyOwner("alice")},
+		{Content: "hello", From: "bob", To: "c@example.com", Owner: auth.KeyOwner("bob")},
+	}
+	for i := range msgs {
+		if err := store.Insert(&msgs[i], nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+// signJWT возвращает JWT с областью messages для subject sub.
+func signJWT(sub string) string {
+	enc := base64.RawURLEncoding
+	payload := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		enc.EncodeToString([]byte(fmt.Sprintf(`{"sub":%q,"scope":"messages"}`, sub)))
+
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write([]byte(payload))
+	return payload + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+func newTestHandler(store storage.Repository) http.Handler {
+	cfg := config.Default()
+	cfg.Server.Auth = true
+	cfg.Server.AdminKey = testAdminKey
+	cfg.Server.JWTSecret = testSecret
+	cfg.Server.TicketSecret = testTicket
+	cfg.Server.AllowedOrigins = testOrigin
+	cfg.Server.RateLimit = 0
+	cfg.Server.IPRateLimit = 0
+	return New(cfg.Server, cfg.Delivery, store, testPrometheus).routes()
+}
+
+func TestHandlers(t *testing.T) {
+	valid := `{"content":"hi","from":"alice","to":"x@example.com"}`
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		key     string
+		err     error // если задано, хранилище отвечает этой ошибкой
+		headers map[string]string
+
+		wantStatus int
+		wantCode   string
+		wantField  string // поле, указанное в ошибке валидации
+	}{
+		{name: "get own message", method: http.MethodGet, path: "/api/messages/2", key: aliceKey, wantStatus: http.StatusOK},
+		{name: "get missing message", method: http.MethodGet, path: "/api/messages/100", key: aliceKey, wantStatus: http.StatusNotFound, wantCode: codeMessageNotFound},
+		{name: "get foreign message", method: http.MethodGet, path: "/api/messages/3", key: aliceKey, wantStatus: http.StatusNotFound, wantCode: codeMessageNotFound},
+		{name: "get key message with jwt of the same name", method: http.MethodGet, path: "/api/messages/2", key: signJWT("alice"), wantStatus: http.StatusNotFound, wantCode: codeMessageNotFound},
+		{name: "get key message with jwt of admin name", method: http.MethodGet, path: "/api/messages/2", key: signJWT("admin"), wantStatus: http.StatusNotFound, wantCode: codeMessageNotFound},
+		{name: "get invalid id", method: http.MethodGet, path: "/api/messages/abc", key: aliceKey, wantStatus: http.StatusBadRequest, wantCode: codeInvalidMessageID},
+		{name: "get storage not found", method: http.MethodGet, path: "/api/messages/2", key: testAdminKey, err: storage.ErrNotFound, wantStatus: http.StatusNotFound, wantCode: codeMessageNotFound},
+		{name: "get storage unavailable", method: http.MethodGet, path: "/api/messages/2", key: testAdminKey, err: storage.ErrUnavailable, wantStatus: http.StatusServiceUnavailable, wantCode: codeStorageUnavailable},
+		{name: "get storage failure", method: http.MethodGet, path: "/api/messages/2", key: testAdminKey, err: fmt.Errorf("boom"), wantStatus: http.StatusInternalServerError, wantCode: codeGetFailed},
+
+		{name: "list own messages", method: http.MethodGet, path: "/api/messages", key: aliceKey, wantStatus: http.StatusOK},
+		{name: "list invalid status", method: http.MethodGet, path: "/api/messages?status=unknown", key: aliceKey, wantStatus: http.StatusBadRequest, wantCode: codeInvalidStatus},
+		{name: "list storage unavailable", method: http.MethodGet, path: "/api/messages", key: testAdminKey, err: storage.ErrUnavailable, wantStatus: http.StatusServiceUnavailable, wantCode: codeStorageUnavailable},
+
+		{name: "history own message", method: http.MethodGet, path: "/api/messages/1/history", key: aliceKey, wantStatus: http.StatusOK},
+		{name: "history missing message", method: http.MethodGet, path: "/api/messages/100/history", key: aliceKey, wantStatus: http.StatusNotFound, wantCode: codeMessageNotFound},
+		{name: "history storage unavailable", method: http.MethodGet, path: "/api/messages/1/history", key: testAdminKey, err: storage.ErrUnavailable, wantStatus: http.StatusServiceUnavailable, wantCode: codeStorageUnavailable},
+
+		{name: "cancel scheduled message", method: http.MethodDelete, path: "/api/messages/1", key: aliceKey, wantStatus: http.StatusOK},
+		{name: "cancel new message", method: http.MethodDelete, path: "/api/messages/2", key: aliceKey, wantStatus: http.StatusConflict, wantCode: codeMessageNotScheduled},
+		{name: "cancel missing message", method: http.MethodDelete, path: "/api/messages/100", key: aliceKey, wantStatus: http.StatusNotFound, wantCode: codeMessageNotFound},
+		{name: "cancel key message with jwt of the same name", method: http.MethodDelete, path: "/api/messages/1", key: signJWT("alice"), wantStatus: http.StatusNotFound, wantCode: codeMessageNotFound},
+		{name: "cancel foreign message", method: http.MethodDelete, path: "/api/messages/1", key: bobKey, wantStatus: http.StatusNotFound, wantCode: codeMessageNotFound},
+		{name: "cancel storage conflict", method: http.MethodDelete, path: "/api/messages/1", key: testAdminKey, err: storage.ErrConflict, wantStatus: http.StatusConflict, wantCode: codeConflict},
+
+		{name: "create message", method: http.MethodPost, path: "/api/messages", body: valid, key: aliceKey, wantStatus: http.StatusCreated},
+		{name: "create invalid json", method: http.MethodPost, path: "/api/messages", body: "{", key: aliceKey, wantStatus: http.StatusBadRequest, wantCode: codeInvalidMessage},
+		{name: "create invalid fields", method: http.MethodPost, path: "/api/messages", body: `{"from":"alice","to":"x@example.com"}`, key: aliceKey, wantStatus: http.StatusBadRequest, wantCode: codeValidationFailed},
+		{name: "create with callback", method: http.MethodPost, path: "/api/messages", body: `{"content":"hi","from":"alice","to":"x@example.com","callback_url":"https://example.com/hook"}`, key: aliceKey, wantStatus: http.StatusCreated},
+		{name: "create with loopback callback", method: http.MethodPost, path: "/api/messages", body: `{"content":"hi","from":"alice","to":"x@example.com","callback_url":"http://127.0.0.1:8081/metrics"}`, key: aliceKey, wantStatus: http.StatusBadRequest, wantCode: codeValidationFailed},
+		{name: "create with metadata callback", method: http.MethodPost, path: "/api/messages", body: `{"content":"hi","from":"alice","to":"x@example.com","callback_url":"http://169.254.169.254/latest"}`, key: aliceKey, wantStatus: http.StatusBadRequest, wantCode: codeValidationFailed},
+		{name: "create with localhost callback", method: http.MethodPost, path: "/api/messages", body: `{"content":"hi","from":"alice","to":"x@example.com","callback_url":"http://localhost/hook"}`, key: aliceKey, wantStatus: http.StatusBadRequest, wantCode: codeValidationFailed},
+		{name: "create with idempotency key", method: http.MethodPost, path: "/api/messages", body: valid, key: aliceKey, headers: map[string]string{"Idempotency-Key": strings.Repeat("k", maxClientIDLength)}, wantStatus: http.StatusCreated},
+		{name: "create with too long idempotency key", method: http.MethodPost, path: "/api/messages", body: valid, key: aliceKey, headers: map[string]string{"Idempotency-Key": strings.Repeat("k", maxClientIDLength+1)}, wantStatus: http.StatusBadRequest, wantCode: codeValidationFailed, wantField: "idempotency_key"},
+		{name: "create too large", method: http.MethodPost, path: "/api/messages", body: strings.Repeat(" ", 2<<20) + valid, key: aliceKey, wantStatus: http.StatusRequestEntityTooLarge, wantCode: codeBodyTooLarge},
+		{name: "create storage unavailable", method: http.MethodPost, path: "/api/messages", body: valid, key: testAdminKey, err: storage.ErrUnavailable, wantStatus: http.StatusServiceUnavailable, wantCode: codeStorageUnavailable},
+		{name: "create storage conflict", method: http.MethodPost, path: "/api/messages", body: valid, key: testAdminKey, err: storage.ErrConflict, wantStatus: http.StatusConflict, wantCode: codeConflict},
+
+		{name: "missing key", method: http.MethodGet, path: "/api/messages", wantStatus: http.StatusUnauthorized, wantCode: codeAPIKeyMissing},
+		{name: "unknown key", method: http.MethodGet, path: "/api/messages", key: "msg_unknown", wantStatus: http.StatusUnauthorized, wantCode: codeAPIKeyInvalid},
+		{name: "metrics without admin scope", method: http.MethodGet, path: "/metrics", key: aliceKey, wantStatus: http.StatusForbidden, wantCode: codeForbidden},
+		{name: "metrics with admin key", method: http.MethodGet, path: "/metrics", key: testAdminKey, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var store storage.Repository = newTestStorage(t)
+			if tt.err != nil {
+				store = failingStorage{Repository: store, err: tt.err}
+			}
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			newTestHandler(store).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+
+			var resp responseError
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode body: %v, body: %s", err, rec.Body)
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", resp.Code, tt.wantCode)
+			}
+			if tt.wantField != "" && (len(resp.Errors) != 1 || resp.Errors[0].Field != tt.wantField) {
+				t.Errorf("errors = %+v, want one for %q", resp.Errors, tt.wantField)
+			}
+			if tt.wantStatus == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+				t.Error("Retry-After is not set")
+			}
+		})
+	}
+}
+
+func TestErrorFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+
+		wantContentType string
+		wantLanguage    string
+		wantText        string
+	}{
+		{
+			name:            "default",
+			wantContentType: "application/json",
+			wantLanguage:    langRu,
+			wantText:        localize(langRu, codeMessageNotFound),
+		},
+		{
+			name:            "english",
+			headers:         map[string]string{"Accept-Language": "en-US,en;q=0.9"},
+			wantContentType: "application/json",
+			wantLanguage:    langEn,
+			wantText:        localize(langEn, codeMessageNotFound),
+		},
+		{
+			name:            "problem details",
+			headers:         map[string]string{"Accept": problemContentType, "Accept-Language": "en"},
+			wantContentType: problemContentType,
+			wantLanguage:    langEn,
+			wantText:        localize(langEn, codeMessageNotFound),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/messages/100", nil)
+			req.Header.Set("X-API-Key", aliceKey)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			newTestHandler(newTestStorage(t)).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNotFound {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			if got := rec.Header().Get("Content-Language"); got != tt.wantLanguage {
+				t.Errorf("Content-Language = %q, want %q", got, tt.wantLanguage)
+			}
+
+			var body struct {
+				Code   string `json:"code"`
+				Text   string `json:"text"`
+				Detail string `json:"detail"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if text := body.Text + body.Detail; text != tt.wantText {
+				t.Errorf("text = %q, want %q", text, tt.wantText)
+			}
+			if body.Code != codeMessageNotFound {
+				t.Errorf("code = %q, want %q", body.Code, codeMessageNotFound)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeyOwners(t *testing.T) {
+	handler := newTestHandler(newTestStorage(t))
+
+	// Шаги выполняются по порядку на одном хранилище, wantSameAs — номер шага с тем же сообщением
+	steps := []struct {
+		name    string
+		key     string
+		content string
+		ttl     int64
+
+		wantStatus int
+		wantSameAs int
+	}{
+		{name: "alice creates", key: aliceKey, content: "hi", ttl: 60, wantStatus: http.StatusCreated, wantSameAs: -1},
+		{name: "bob creates with the same key", key: bobKey, content: "hi", wantStatus: http.StatusCreated, wantSameAs: -1},
+		{name: "alice replays", key: aliceKey, content: "hi", ttl: 60, wantStatus: http.StatusOK, wantSameAs: 0},
+		{name: "bob replays", key: bobKey, content: "hi", wantStatus: http.StatusOK, wantSameAs: 1},
+		{name: "bob reuses the key", key: bobKey, content: "bye", wantStatus: http.StatusConflict, wantSameAs: -1},
+		{name: "alice reuses the key without ttl", key: aliceKey, content: "hi", wantStatus: http.StatusConflict, wantSameAs: -1},
+		{name: "alice reuses the key with another ttl", key: aliceKey, content: "hi", ttl: 120, wantStatus: http.StatusConflict, wantSameAs: -1},
+	}
+
+	ids := make([]int, len(steps))
+	for i, step := range steps {
+		body := fmt.Sprintf(`{"content":%q,"from":"alice","to":"x@example.com","ttl":%d}`, step.content, step.ttl)
+		req := httptest.NewRequest(http.MethodPost, "/api/messages", strings.NewReader(body))
+		req.Header.Set("X-API-Key", step.key)
+		req.Header.Set("Idempotency-Key", "k1")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != step.wantStatus {
+			t.Fatalf("%s: status = %d, want %d, body: %s", step.name, rec.Code, step.wantStatus, rec.Body)
+		}
+		if rec.Code == http.StatusConflict {
+			continue
+		}
+
+		var resp responseMessage
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = resp.Message.ID
+
+		for j := 0; j < i; j++ {
+			if ids[j] != 0 && (ids[j] == ids[i]) != (j == step.wantSameAs) {
+				t.Errorf("%s: id = %d, step %q id = %d", step.name, ids[i], steps[j].name, ids[j])
+			}
+		}
+	}
+}
+
+func TestStreamAuth(t *testing.T) {
+	alice := auth.Principal{Owner: auth.KeyOwner("alice"), Scopes: []string{model.ScopeMessages}}
+	valid := auth.SignTicket(alice, []byte(testTicket), time.Now().Add(time.Minute))
+	expired := auth.SignTicket(alice, []byte(testTicket), time.Now().Add(-time.Second))
+	forged := auth.SignTicket(alice, []byte("other-secret-0123456789-0123456789"), time.Now().Add(time.Minute))
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		key     string
+		headers map[string]string
+
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "issue ticket", method: http.MethodPost, path: "/api/messages/stream/ticket", key: aliceKey, wantStatus: http.StatusCreated},
+		{name: "issue ticket without key", method: http.MethodPost, path: "/api/messages/stream/ticket", wantStatus: http.StatusUnauthorized, wantCode: codeAPIKeyMissing},
+		{name: "issue ticket with ticket", method: http.MethodPost, path: "/api/messages/stream/ticket?ticket=" + valid, wantStatus: http.StatusUnauthorized, wantCode: codeAPIKeyMissing},
+
+		{name: "sse with key", method: http.MethodGet, path: "/api/messages/stream", key: aliceKey, wantStatus: http.StatusOK},
+		{name: "sse with ticket", method: http.MethodGet, path: "/api/messages/stream?ticket=" + valid, wantStatus: http.StatusOK},
+		{name: "sse with expired ticket", method: http.MethodGet, path: "/api/messages/stream?ticket=" + expired, wantStatus: http.StatusUnauthorized, wantCode: codeTokenExpired},
+		{name: "sse with forged ticket", method: http.MethodGet, path: "/api/messages/stream?ticket=" + forged, wantStatus: http.StatusUnauthorized, wantCode: codeAPIKeyInvalid},
+		{name: "sse with api key as ticket", method: http.MethodGet, path: "/api/messages/stream?ticket=" + aliceKey, wantStatus: http.StatusUnauthorized, wantCode: codeAPIKeyInvalid},
+		{name: "list with ticket", method: http.MethodGet, path: "/api/messages?ticket=" + valid, wantStatus: http.StatusUnauthorized, wantCode: codeAPIKeyMissing},
+		{name: "get with ticket", method: http.MethodGet, path: "/api/messages/2?ticket=" + valid, wantStatus: http.StatusUnauthorized, wantCode: codeAPIKeyMissing},
+
+		{
+			name:       "websocket from foreign origin",
+			method:     http.MethodGet,
+			path:       "/api/messages/stream/ws?ticket=" + valid,
+			headers:    map[string]string{"Origin": "https://evil.example.com"},
+			wantStatus: http.StatusForbidden,
+			wantCode:   codeOriginForbidden,
+		},
+		{
+			name:       "websocket from foreign origin with key",
+			method:     http.MethodGet,
+			path:       "/api/messages/stream/ws",
+			key:        aliceKey,
+			headers:    map[string]string{"Origin": "null"},
+			wantStatus: http.StatusForbidden,
+			wantCode:   codeOriginForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Поток SSE завершается сразу после заголовков, потому что контекст уже отменён
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			req := httptest.NewRequest(tt.method, tt.path, nil).WithContext(ctx)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			newTestHandler(newTestStorage(t)).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+
+			var resp responseError
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode body: %v, body: %s", err, rec.Body)
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", resp.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAllowedOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed string
+		origin  string
+		want    bool
+	}{
+		{name: "no origin", origin: "", want: true},
+		{name: "same host", origin: "http://example.com", want: true},
+		{name: "foreign host", origin: "https://evil.example.com", want: false},
+		{name: "listed", allowed: "https://a.example.com, https://app.example.com", origin: "https://app.example.com", want: true},
+		{name: "listed with other scheme", allowed: "https://app.example.com", origin: "http://app.example.com", want: false},
+		{name: "any", allowed: "*", origin: "https://evil.example.com", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Server.AllowedOrigins = tt.allowed
+			s := New(cfg.Server, cfg.Delivery, newTestStorage(t), testPrometheus)
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/api/messages/stream/ws", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := s.allowedOrigin(req); got != tt.want {
+				t.Errorf("allowed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDailyQuota(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.Auth = true
+	cfg.Server.RateLimit = 0
+	cfg.Server.IPRateLimit = 0
+	cfg.Server.DailyQuota = 3
+	handler := New(cfg.Server, cfg.Delivery, newTestStorage(t), testPrometheus).routes()
+
+	valid := `{"content":"hi","from":"alice","to":"x@example.com"}`
+
+	// Шаги выполняются по порядку на одном хранилище, квота — 3 сообщения
+	steps := []struct {
+		name           string
+		path           string
+		body           string
+		idempotencyKey string
+
+		wantStatus int
+	}{
+		{name: "create with key", path: "/api/messages", body: valid, idempotencyKey: "k1", wantStatus: http.StatusCreated},
+		{name: "replay is free", path: "/api/messages", body: valid, idempotencyKey: "k1", wantStatus: http.StatusOK},
+		{name: "replay again is free", path: "/api/messages", body: valid, idempotencyKey: "k1", wantStatus: http.StatusOK},
+		{name: "batch charges inserted only", path: "/api/messages/batch", body: "[" + valid + `,{"content":""}]`, wantStatus: http.StatusMultiStatus},
+		{name: "create last", path: "/api/messages", body: valid, wantStatus: http.StatusCreated},
+		{name: "replay with quota spent", path: "/api/messages", body: valid, idempotencyKey: "k1", wantStatus: http.StatusOK},
+		{name: "create over quota", path: "/api/messages", body: valid, wantStatus: http.StatusTooManyRequests},
+		{name: "create with key over quota", path: "/api/messages", body: valid, idempotencyKey: "k2", wantStatus: http.StatusTooManyRequests},
+		{name: "batch over quota", path: "/api/messages/batch", body: "[" + valid + "]", wantStatus: http.StatusTooManyRequests},
+	}
+
+	for _, step := range steps {
+		req := httptest.NewRequest(http.MethodPost, step.path, strings.NewReader(step.body))
+		req.Header.Set("X-API-Key", aliceKey)
+		if step.idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", step.idempotencyKey)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != step.wantStatus {
+			t.Fatalf("%s: status = %d, want %d, body: %s", step.name, rec.Code, step.wantStatus, rec.Body)
+		}
+		if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: Retry-After is not set", step.name)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.Auth = true
+	cfg.Server.RateLimit = 1
+	cfg.Server.RateBurst = 1
+	cfg.Server.IPRateLimit = 1
+	cfg.Server.IPRateBurst = 2
+	handler := New(cfg.Server, cfg.Delivery, newTestStorage(t), testPrometheus).routes()
+
+	// Шаги выполняются по порядку: корзина IP проверяется до ключа, корзина владельца — после
+	steps := []struct {
+		name string
+		ip   string
+		key  string
+
+		wantStatus int
+	}{
+		{name: "invalid key", ip: "10.0.0.1", key: "msg_unknown", wantStatus: http.StatusUnauthorized},
+		{name: "invalid key again", ip: "10.0.0.1", key: "msg_unknown", wantStatus: http.StatusUnauthorized},
+		{name: "ip bucket empty before auth", ip: "10.0.0.1", key: "msg_unknown", wantStatus: http.StatusTooManyRequests},
+		{name: "ip bucket empty for valid key", ip: "10.0.0.1", key: aliceKey, wantStatus: http.StatusTooManyRequests},
+		{name: "other ip", ip: "10.0.0.2", key: aliceKey, wantStatus: http.StatusOK},
+		{name: "owner bucket empty", ip: "10.0.0.3", key: aliceKey, wantStatus: http.StatusTooManyRequests},
+		{name: "other owner", ip: "10.0.0.3", key: bobKey, wantStatus: http.StatusOK},
+	}
+
+	for _, step := range steps {
+		req := httptest.NewRequest(http.MethodGet, "/api/messages", nil)
+		req.RemoteAddr = step.ip + ":1234"
+		req.Header.Set("X-API-Key", step.key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != step.wantStatus {
+			t.Fatalf("%s: status = %d, want %d, body: %s", step.name, rec.Code, step.wantStatus, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+	"messaggio/auth"
+	"messaggio/model"
+)
+
+const (
+	subscriberBuffer  = 64
+	heartbeatInterval = 15 * time.Second
+)
+
+type subscriber struct {
+	messageID int
+	owner     string
+	from      string
+	to        string
+	events    chan model.Event
+}
+
+func (s *subscriber) match(e model.Event) bool {
+	return (s.owner == "" || s.owner == e.Owner) &&
+		(s.messageID == 0 || s.messageID == e.MessageID) &&
+		(s.from == "" || s.from == e.From) &&
+		(s.to == "" || s.to == e.To)
+}
+
+// hub раздаёт события изменения статусов, полученные через LISTEN/NOTIFY, подписчикам SSE и WebSocket.
+type hub struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+	done chan struct{}
+}
+
+func newHub() *hub {
+	return &hub{
+		subs: make(map[*subscriber]struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+func (h *hub) subscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[sub] = struct{}{}
+}
+
+func (h *hub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, sub)
+}
+
+func (h *hub) publish(e model.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		if !sub.match(e) {
+			continue
+		}
+
+		select {
+		case sub.events <- e:
+		default:
+			log.Warnf("stream subscriber is too slow, event %d dropped", e.ID)
+		}
+	}
+}
+
+func (h *hub) close() {
+	close(h.done)
+}
+
+// listen переподключается к LISTEN/NOTIFY, пока не будет отменён ctx.
+func (s *Server) listen(ctx context.Context) {
+	for {
+		err := s.storage.ListenEvents(ctx, s.hub.publish)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Error(err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+type responseTicket struct {
+	Status    string `json:"status"`
+	Ticket    string `json:"ticket"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func (r responseTicket) Write(w http.ResponseWriter, code int) {
+	w.WriteHeader(code)
+	marshal, _ := json.Marshal(r)
+	_, _ = w.Write(marshal)
+}
+
+// @Summary Issue a stream ticket
+// @Description Short-lived ticket for the ticket query parameter of the stream endpoints,
+// @Description because browser EventSource and WebSocket cannot send X-API-Key or Authorization.
+// @Produce  json
+// @Success 201 {object} responseTicket
+// @Failure 401 {object} responseError
+// @Failure 403 {object} responseError
+// @Failure 429 {object} responseError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/messages/stream/ticket [post]
+func (s *Server) createTicket(w http.ResponseWriter, r *http.Request) {
+	p, _ := auth.FromContext(r.Context())
+	exp := time.Now().Add(s.cfg.TicketTTL)
+
+	responseTicket{
+		Status:    http.StatusText(http.StatusCreated),
+		Ticket:    auth.SignTicket(p, s.ticketSecret, exp),
+		ExpiresAt: exp.Unix(),
+	}.Write(w, http.StatusCreated)
+}
+
+// allowedOrigin сообщает, можно ли открыть WebSocket со страницы r.Header["Origin"]. Без проверки
+// любая страница могла бы открыть поток от имени пользователя с билетом или cookie прокси.
+// Клиенты не из браузера Origin не передают и пропускаются.
+func (s *Server) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	for _, allowed := range strings.Split(s.cfg.AllowedOrigins, ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "*" || (allowed != "" && strings.EqualFold(allowed, origin)) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseSubscriber(r *http.Request) (*subscriber, error) {
+	query := r.URL.Query()
+	id, err := queryInt(query, "id", 0)
+	if err != nil || id < 0 {
+		return nil, fmt.Errorf("invalid id %q", query.Get("id"))
+	}
+
+	return &subscriber{
+		messageID: id,
+		owner:     ownerFilter(r),
+		from:      query.Get("from"),
+		to:        query.Get("to"),
+		events:    make(chan model.Event, subscriberBuffer),
+	}, nil
+}
+
+// @Summary Stream message status changes (SSE)
+// @Description Server-sent events with status transitions. Without filters all messages are streamed.
+// @Produce  text/event-stream
+// @Param id query int false "Message ID"
+// @Param from query string false "Sender filter"
+// @Param to query string false "Recipient filter"
+// @Param ticket query string false "Stream ticket instead of X-API-Key or Authorization"
+// @Success 200 {object} model.Event
+// @Failure 400 {object} responseError
+// @Failure 500 {object} responseError
+// @Failure 401 {object} responseError
+// @Failure 403 {object} responseError
+// @Failure 429 {object} responseError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/messages/stream [get]
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	sub, err := parseSubscriber(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidMessageID)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, codeStreamingUnsupported)
+		return
+	}
+
+	s.hub.subscribe(sub)
+	defer s.hub.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.hub.done:
+			return
+		case <-heartbeat.C:
+			if _, err = fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case e := <-sub.events:
+			data, _ := json.Marshal(e)
+			if _, err = fmt.Fprintf(w, "id: %d\nevent: status\ndata: %s\n\n", e.ID, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// @Summary Stream message status changes (WebSocket)
+// @Description WebSocket with status transitions as JSON text frames. Without filters all messages are streamed.
+// @Param id query int false "Message ID"
+// @Param from query string false "Sender filter"
+// @Param to query string false "Recipient filter"
+// @Param ticket query string false "Stream ticket instead of X-API-Key or Authorization"
+// @Success 101 {object} model.Event
+// @Failure 400 {object} responseError
+// @Failure 401 {object} responseError
+// @Failure 403 {object} responseError
+// @Failure 429 {object} responseError
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/messages/stream/ws [get]
+func (s *Server) streamEventsWS(w http.ResponseWriter, r *http.Request) {
+	if !s.allowedOrigin(r) {
+		writeError(w, r, http.StatusForbidden, codeOriginForbidden)
+		return
+	}
+
+	sub, err := parseSubscriber(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidMessageID)
+		return
+	}
+
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		s.hub.subscribe(sub)
+		defer s.hub.unsubscribe(sub)
+
+		// Входящие сообщения не ожидаются, чтение нужно только чтобы заметить закрытие соединения
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			var discard string
+			for websocket.Message.Receive(ws, &discard) == nil {
+			}
+		}()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case <-s.hub.done:
+				_ = ws.Close()
+				return
+			case e := <-sub.events:
+				if err := websocket.JSON.Send(ws, e); err != nil {
+					return
+				}
+			}
+		}
+	}}.ServeHTTP(w, r)
+}
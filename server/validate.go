@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"messaggio/delivery"
+)
+
+const (
+	maxFromLength     = 256
+	maxAddressLength  = 2048
+	maxClientIDLength = 256
+)
+
+// fieldError — ошибка проверки одного поля запроса. Text заполняется из каталога при ответе.
+type fieldError struct {
+	Field string `json:"field"`
+	Code  string `json:"code"`
+	Text  string `json:"text"`
+
+	args []interface{}
+}
+
+// validate проверяет сообщение и возвращает ошибки по всем полям сразу.
+// Формат to проверяется по правилам канала, в который сообщение будет направлено.
+func (s *Server) validate(req request) []fieldError {
+	var errs []fieldError
+	add := func(field, code string, args ...interface{}) {
+		errs = append(errs, fieldError{Field: field, Code: code, args: args})
+	}
+
+	switch {
+	case strings.TrimSpace(req.Content) == "":
+		add("content", codeRequired)
+	case utf8.RuneCountInString(req.Content) > s.cfg.MaxContentLength:
+		add("content", codeTooLong, s.cfg.MaxContentLength)
+	}
+
+	// from попадает в заголовки письма и тело webhook, поэтому общие правила для всех каналов
+	switch {
+	case strings.TrimSpace(req.From) == "":
+		add("from", codeRequired)
+	case utf8.RuneCountInString(req.From) > maxFromLength:
+		add("from", codeTooLong, maxFromLength)
+	case hasControl(req.From):
+		add("from", codeControlCharacters)
+	}
+
+	switch {
+	case strings.TrimSpace(req.To) == "":
+		add("to", codeRequired)
+	case len(req.To) > maxAddressLength:
+		add("to", codeTooLong, maxAddressLength)
+	case hasControl(req.To):
+		add("to", codeControlCharacters)
+	default:
+		channel, err := delivery.Validate(req.To, s.delivery)
+		switch {
+		case errors.Is(err, delivery.ErrInvalidURL):
+			add("to", codeInvalidWebhookURL)
+		case errors.Is(err, delivery.ErrInvalidEmail):
+			add("to", codeInvalidEmail)
+		case errors.Is(err, delivery.ErrEmptyAddress):
+			add("to", codeEmptyAddress, channel)
+		case err != nil:
+			add("to", codeUnsupportedScheme)
+		}
+	}
+
+	if len(req.ClientID) > maxClientIDLength {
+		add("client_id", codeTooLong, maxClientIDLength)
+	}
+	if len(req.CallbackURL) > maxAddressLength || !validCallbackURL(req.CallbackURL) {
+		add("callback_url", codeInvalidCallbackURL)
+	}
+	if req.SendAt < 0 {
+		add("send_at", codeInvalidSendAt)
+	}
+	if !validPriority(req.Priority) {
+		add("priority", codeInvalidPriority)
+	}
+	if req.TTL < 0 {
+		add("ttl", codeInvalidTTL)
+	}
+
+	return errs
+}
+
+func hasControl(s string) bool {
+	return strings.IndexFunc(s, unicode.IsControl) >= 0
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// Ошибки, по которым вызывающий выбирает реакцию, не зная о конкретной базе.
+// Исходная ошибка драйвера остаётся в цепочке и доступна через errors.As.
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrUnavailable = errors.New("storage unavailable")
+)
+
+// ErrNotScheduled возвращается при отмене сообщения, которое уже не ожидает отправки.
+var ErrNotScheduled = fmt.Errorf("message is not scheduled: %w", ErrConflict)
+
+// ErrStatusLocked возвращается при попытке сменить статус отменённого или просроченного сообщения.
+var ErrStatusLocked = fmt.Errorf("message is canceled or expired: %w", ErrConflict)
+
+// ErrQuotaExceeded возвращается, если сообщения не помещаются в суточную квоту владельца. Сообщения не добавляются.
+var ErrQuotaExceeded = errors.New("daily quota exceeded")
+
+// Ошибки пула go-pg не экспортируются, поэтому сравниваются по тексту.
+var unavailableMessages = []string{
+	"pg: database is closed",
+	"pg: connection pool timeout",
+}
+
+// wrapError переводит ошибки go-pg в ошибки storage. Недоступностью считаются сетевые ошибки,
+// таймауты и временные ошибки сервера: обрыв соединения (08), нехватка ресурсов (53),
+// остановка сервера (57P) и конфликты сериализации (40), после которых запрос можно повторить.
+func wrapError(err error) error {
+	if err == nil || errors.Is(err, ErrNotFound) || errors.Is(err, ErrConflict) || errors.Is(err, ErrUnavailable) {
+		return err
+	}
+
+	if errors.Is(err, pg.ErrNoRows) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	var pgErr pg.Error
+	if errors.As(err, &pgErr) {
+		code := pgErr.Field('C')
+		switch {
+		case pgErr.IntegrityViolation():
+			return fmt.Errorf("%w: %w", ErrConflict, err)
+		case strings.HasPrefix(code, "08"), strings.HasPrefix(code, "53"),
+			strings.HasPrefix(code, "57P"), strings.HasPrefix(code, "40"):
+			return fmt.Errorf("%w: %w", ErrUnavailable, err)
+		}
+		return err
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	for _, msg := range unavailableMessages {
+		if err.Error() == msg {
+			return fmt.Errorf("%w: %w", ErrUnavailable, err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// pgError имитирует ошибку сервера PostgreSQL с кодом SQLSTATE
+type pgError string
+
+func (e pgError) Error() string { return "ERROR #" + string(e) }
+
+func (e pgError) Field(field byte) string {
+	if field == 'C' {
+		return string(e)
+	}
+	return ""
+}
+
+func (e pgError) IntegrityViolation() bool { return string(e[:2]) == "23" }
+
+func TestWrapError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "nil", err: nil, want: nil},
+		{name: "no rows", err: pg.ErrNoRows, want: ErrNotFound},
+		{name: "wrapped no rows", err: fmt.Errorf("select: %w", pg.ErrNoRows), want: ErrNotFound},
+		{name: "unique violation", err: pgError("23505"), want: ErrConflict},
+		{name: "connection failure", err: pgError("08006"), want: ErrUnavailable},
+		{name: "too many connections", err: pgError("53300"), want: ErrUnavailable},
+		{name: "admin shutdown", err: pgError("57P01"), want: ErrUnavailable},
+		{name: "serialization failure", err: pgError("40001"), want: ErrUnavailable},
+		{name: "syntax error", err: pgError("42601"), want: nil},
+		{name: "deadline", err: context.DeadlineExceeded, want: ErrUnavailable},
+		{name: "eof", err: io.EOF, want: ErrUnavailable},
+		{name: "pool closed", err: errors.New("pg: database is closed"), want: ErrUnavailable},
+		{name: "already wrapped", err: ErrNotScheduled, want: ErrConflict},
+		{name: "other", err: errors.New("boom"), want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := wrapError(tt.err)
+			if tt.want == nil {
+				if got != tt.err {
+					t.Fatalf("wrapError(%v) = %v, want unchanged", tt.err, got)
+				}
+				return
+			}
+			if !errors.Is(got, tt.want) {
+				t.Fatalf("wrapError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+			if !errors.Is(got, tt.err) {
+				t.Fatalf("wrapError(%v) = %v, original error lost", tt.err, got)
+			}
+		})
+	}
+}
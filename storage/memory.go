@@ -0,0 +1,506 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"messaggio/config"
+	"messaggio/model"
+)
+
+// Memory — хранилище в памяти процесса для демо и тестов. Данные не переживают перезапуск.
+type Memory struct {
+	cfg config.DataBase
+
+	mu        sync.Mutex
+	messages  map[int]*model.Message
+	events    []model.Event
+	lastID    int
+	listeners map[chan model.Event]struct{}
+
+	callbacks []*model.Callback
+	attempts  []model.CallbackAttempt
+
+	apiKeys []*model.APIKey
+	quotas  map[string]int
+}
+
+func NewMemory(cfg config.DataBase) *Memory {
+	return &Memory{
+		cfg:       cfg,
+		messages:  make(map[int]*model.Message),
+		listeners: make(map[chan model.Event]struct{}),
+		quotas:    make(map[string]int),
+	}
+}
+
+func (m *Memory) Close() {}
+
+// addEvent вызывается под m.mu.
+func (m *Memory) addEvent(msg *model.Message, reason string) {
+	event := model.Event{
+		ID:        len(m.events) + 1,
+		MessageID: msg.ID,
+		Status:    msg.Status,
+		Timestamp: time.Now(),
+		Instance:  m.cfg.Instance,
+		Error:     reason,
+	}
+	m.events = append(m.events, event)
+
+	if model.Status(msg.Status).Final() && msg.CallbackURL != "" {
+		m.callbacks = append(m.callbacks, &model.Callback{
+			ID:            len(m.callbacks) + 1,
+			MessageID:     msg.ID,
+			URL:           msg.CallbackURL,
+			Status:        msg.Status,
+			Error:         reason,
+			Timestamp:     event.Timestamp,
+			State:         model.CallbackPending.String(),
+			NextAttemptAt: event.Timestamp,
+		})
+	}
+
+	event.From, event.To, event.Owner = msg.From, msg.To, msg.Owner
+	for ch := range m.listeners {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// insert вызывается под m.mu.
+func (m *Memory) insert(msg *model.Message) {
+	m.lastID++
+	msg.ID = m.lastID
+	initStatus(msg)
+	msg.Timestamp = time.Now().Unix()
+
+	stored := *msg
+	m.messages[msg.ID] = &stored
+	m.addEvent(&stored, "")
+}
+
+func (m *Memory) Insert(msg *model.Message, q *Quota) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.consumeQuota(q, 1) {
+		return ErrQuotaExceeded
+	}
+	m.insert(msg)
+	return nil
+}
+
+func (m *Memory) InsertOnce(msg *model.Message, retention time.Duration, q *Quota) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expired := time.Now().Add(-retention).Unix()
+	for _, stored := range m.messages {
+		if stored.Owner != msg.Owner || stored.IdempotencyKey != msg.IdempotencyKey {
+			continue
+		}
+		if stored.Timestamp < expired {
+			stored.IdempotencyKey = ""
+			continue
+		}
+
+		*msg = *stored
+		return false, nil
+	}
+
+	if !m.consumeQuota(q, 1) {
+		return false, ErrQuotaExceeded
+	}
+	m.insert(msg)
+	return true, nil
+}
+
+func (m *Memory) InsertBatch(msgs []model.Message, q *Quota) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.consumeQuota(q, len(msgs)) {
+		return ErrQuotaExceeded
+	}
+	for i := range msgs {
+		m.insert(&msgs[i])
+	}
+	return nil
+}
+
+// sorted возвращает копии сообщений по возрастанию id, вызывается под m.mu.
+func (m *Memory) sorted(match func(*model.Message) bool) []model.Message {
+	var msgs []model.Message
+	for _, msg := range m.messages {
+		if match(msg) {
+			msgs = append(msgs, *msg)
+		}
+	}
+
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].ID < msgs[j].ID
+	})
+	return msgs
+}
+
+func (m *Memory) SelectAll(f Filter) ([]model.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	content := strings.ToLower(f.Content)
+	msgs := m.sorted(func(msg *model.Message) bool {
+		return (f.Owner == "" || msg.Owner == f.Owner) &&
+			(f.Status == "" || msg.Status == f.Status) &&
+			(f.From == "" || msg.From == f.From) &&
+			(f.To == "" || msg.To == f.To) &&
+			(content == "" || strings.Contains(strings.ToLower(msg.Content), content)) &&
+			(f.Since == 0 || msg.Timestamp >= f.Since) &&
+			(f.Until == 0 || msg.Timestamp <= f.Until) &&
+			(f.AfterID == 0 || (f.Desc && msg.ID < f.AfterID) || (!f.Desc && msg.ID > f.AfterID))
+	})
+
+	if f.Desc {
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
+	}
+
+	if f.Limit > 0 && len(msgs) > f.Limit {
+		msgs = msgs[:f.Limit]
+	}
+	return msgs, nil
+}
+
+func (m *Memory) SelectById(id int) (model.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg, ok := m.messages[id]
+	if !ok {
+		return model.Message{}, ErrNotFound
+	}
+	return *msg, nil
+}
+
+func (m *Memory) SelectNew() ([]model.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.sorted(func(msg *model.Message) bool {
+		return msg.Status == model.New.String()
+	}), nil
+}
+
+func (m *Memory) SelectEvents(id int) ([]model.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []model.Event
+	for _, event := range m.events {
+		if event.MessageID == id {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (m *Memory) CountByStatus() (map[model.Status]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make(map[model.Status]int)
+	for _, msg := range m.messages {
+		counts[model.Status(msg.Status)]++
+	}
+	return counts, nil
+}
+
+// Claim сразу переводит сообщения в processing, Release возвращает в new те, что ещё не обработаны.
+func (m *Memory) Claim(_ context.Context, limit int) (*Claim, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	msgs := m.sorted(func(msg *model.Message) bool {
+		return (msg.Status == model.New.String() ||
+			(msg.Status == model.Scheduled.String() && msg.SendAt <= now.Unix())) &&
+			!msg.Expired(now)
+	})
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].Priority > msgs[j].Priority
+	})
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+	}
+
+	// Откат возвращает каждому сообщению статус до выборки, как откат транзакции в PostgreSQL
+	previous := make(map[int]string, len(msgs))
+	for i := range msgs {
+		stored := m.messages[msgs[i].ID]
+		previous[stored.ID] = stored.Status
+		stored.Status = model.Processing.String()
+		m.addEvent(stored, "")
+		msgs[i] = *stored
+	}
+
+	return &Claim{
+		Messages: msgs,
+		commit: func() error {
+			return nil
+		},
+		rollback: func() error {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			for _, msg := range msgs {
+				stored := m.messages[msg.ID]
+				if stored.Status == model.Processing.String() {
+					stored.Status = previous[msg.ID]
+					m.addEvent(stored, "")
+				}
+			}
+			return nil
+		},
+	}, nil
+}
+
+func (m *Memory) Ack(c *Claim) error {
+	return c.finish(c.commit)
+}
+
+func (m *Memory) Release(c *Claim) error {
+	return c.finish(c.rollback)
+}
+
+func (m *Memory) UpdateStatus(id int, status model.Status) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg, ok := m.messages[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if slices.Contains(lockedStatuses, model.Status(msg.Status)) {
+		return ErrStatusLocked
+	}
+
+	msg.Status = status.String()
+	m.addEvent(msg, "")
+	return nil
+}
+
+// UpdateStatuses, как и в PostgreSQL, пропускает неизвестные, отменённые и просроченные сообщения.
+func (m *Memory) UpdateStatuses(msgs []model.Message, status model.Status) error {
+	for _, msg := range msgs {
+		err := m.UpdateStatus(msg.ID, status)
+		if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrStatusLocked) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Fail(id int, reason string, maxAttempts int) (model.Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg, ok := m.messages[id]
+	if !ok {
+		return "", ErrNotFound
+	}
+	if slices.Contains(lockedStatuses, model.Status(msg.Status)) {
+		return "", ErrStatusLocked
+	}
+
+	msg.Attempts++
+	msg.LastError = reason
+	msg.Status = model.New.String()
+	if msg.Attempts >= maxAttempts {
+		msg.Status = model.Error.String()
+	}
+	m.addEvent(msg, reason)
+	return model.Status(msg.Status), nil
+}
+
+func (m *Memory) Cancel(id int) (model.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg, ok := m.messages[id]
+	if !ok {
+		return model.Message{}, ErrNotFound
+	}
+	if msg.Status != model.Scheduled.String() {
+		return *msg, ErrNotScheduled
+	}
+
+	msg.Status = model.Canceled.String()
+	m.addEvent(msg, "")
+	return *msg, nil
+}
+
+func (m *Memory) Expire(_ context.Context, limit int) (map[model.Status]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	msgs := m.sorted(func(msg *model.Message) bool {
+		status := model.Status(msg.Status)
+		return (status == model.New || status == model.Scheduled || status == model.Processing) && msg.Expired(now)
+	})
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+	}
+
+	counts := make(map[model.Status]int)
+	for _, msg := range msgs {
+		counts[model.Status(msg.Status)]++
+
+		stored := m.messages[msg.ID]
+		stored.Status = model.Expired.String()
+		m.addEvent(stored, "")
+	}
+	return counts, nil
+}
+
+func (m *Memory) ClaimCallbacks(_ context.Context, limit int, lease time.Duration) ([]model.Callback, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var callbacks []model.Callback
+	for _, cb := range m.callbacks {
+		if len(callbacks) == limit {
+			break
+		}
+		if cb.State != model.CallbackPending.String() || cb.NextAttemptAt.After(now) {
+			continue
+		}
+
+		cb.NextAttemptAt = now.Add(lease)
+		callbacks = append(callbacks, *cb)
+	}
+	return callbacks, nil
+}
+
+func (m *Memory) SaveCallbackAttempt(cb *model.Callback, attempt *model.CallbackAttempt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cb.ID < 1 || cb.ID > len(m.callbacks) {
+		return ErrNotFound
+	}
+
+	stored := m.callbacks[cb.ID-1]
+	stored.State, stored.Attempts, stored.NextAttemptAt = cb.State, cb.Attempts, cb.NextAttemptAt
+
+	attempt.ID = len(m.attempts) + 1
+	attempt.Timestamp = time.Now()
+	m.attempts = append(m.attempts, *attempt)
+	return nil
+}
+
+func (m *Memory) SelectCallbackAttempts(messageID int) ([]model.CallbackAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var attempts []model.CallbackAttempt
+	for _, attempt := range m.attempts {
+		if attempt.MessageID == messageID {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts, nil
+}
+
+func (m *Memory) CreateAPIKey(key *model.APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key.ID = len(m.apiKeys) + 1
+	key.CreatedAt = time.Now()
+	stored := *key
+	m.apiKeys = append(m.apiKeys, &stored)
+	return nil
+}
+
+func (m *Memory) SelectAPIKey(hash string) (model.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range m.apiKeys {
+		if key.KeyHash == hash && key.RevokedAt == nil {
+			return *key, nil
+		}
+	}
+	return model.APIKey{}, ErrNotFound
+}
+
+func (m *Memory) SelectAPIKeys() ([]model.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]model.APIKey, len(m.apiKeys))
+	for i, key := range m.apiKeys {
+		keys[i] = *key
+	}
+	return keys, nil
+}
+
+func (m *Memory) RevokeAPIKey(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > len(m.apiKeys) || m.apiKeys[id-1].RevokedAt != nil {
+		return ErrNotFound
+	}
+
+	now := time.Now()
+	m.apiKeys[id-1].RevokedAt = &now
+	return nil
+}
+
+// consumeQuota вызывается под m.mu до добавления сообщений.
+func (m *Memory) consumeQuota(q *Quota, n int) bool {
+	if q == nil {
+		return true
+	}
+
+	key := q.Owner + "/" + q.Day.Format(time.DateOnly)
+	if m.quotas[key]+n > q.Limit {
+		return false
+	}
+	m.quotas[key] += n
+	return true
+}
+
+func (m *Memory) ListenEvents(ctx context.Context, fn func(model.Event)) error {
+	ch := make(chan model.Event, 64)
+
+	m.mu.Lock()
+	m.listeners[ch] = struct{}{}
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.listeners, ch)
+		m.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event := <-ch:
+			fn(event)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"messaggio/config"
+	"messaggio/model"
+)
+
+// newTestMemory возвращает хранилище с сообщениями: 1 — новое, 2 — запланированное, 3 — новое с высоким приоритетом.
+func newTestMemory(t *testing.T) *Memory {
+	t.Helper()
+
+	m := NewMemory(config.DataBase{})
+	msgs := []model.Message{
+		{Content: "one", From: "alice", To: "a@example.com", Owner: "alice"},
+		{Content: "two", From: "alice", To: "b@example.com", Owner: "alice", SendAt: time.Now().Add(time.Hour).Unix()},
+		{Content: "three", From: "bob", To: "c@example.com", Owner: "bob", Priority: model.PriorityHigh},
+	}
+	if err := m.InsertBatch(msgs, nil); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMemoryNotFound(t *testing.T) {
+	m := newTestMemory(t)
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{name: "select", call: func() error { _, err := m.SelectById(100); return err }},
+		{name: "update status", call: func() error { return m.UpdateStatus(100, model.Ok) }},
+		{name: "fail", call: func() error { _, err := m.Fail(100, "boom", 3); return err }},
+		{name: "cancel", call: func() error { _, err := m.Cancel(100); return err }},
+		{name: "api key", call: func() error { _, err := m.SelectAPIKey("unknown"); return err }},
+		{name: "revoke api key", call: func() error { return m.RevokeAPIKey(100) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("error = %v, want %v", err, ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestMemoryStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		id         int
+		call       func(m *Memory, id int) error
+		wantStatus model.Status
+		wantErr    error
+	}{
+		{name: "initial new", id: 1, call: func(*Memory, int) error { return nil }, wantStatus: model.New},
+		{name: "initial scheduled", id: 2, call: func(*Memory, int) error { return nil }, wantStatus: model.Scheduled},
+		{
+			name:       "update status",
+			id:         1,
+			call:       func(m *Memory, id int) error { return m.UpdateStatus(id, model.Ok) },
+			wantStatus: model.Ok,
+		},
+		{
+			name:       "fail with attempts left",
+			id:         1,
+			call:       func(m *Memory, id int) error { _, err := m.Fail(id, "boom", 2); return err },
+			wantStatus: model.New,
+		},
+		{
+			name: "fail with attempts exhausted",
+			id:   1,
+			call: func(m *Memory, id int) error {
+				for i := 0; i < 2; i++ {
+					if _, err := m.Fail(id, "boom", 2); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			wantStatus: model.Error,
+		},
+		{
+			name: "ok after expired",
+			id:   1,
+			call: func(m *Memory, id int) error {
+				if err := m.UpdateStatus(id, model.Expired); err != nil {
+					return err
+				}
+				return m.UpdateStatus(id, model.Ok)
+			},
+			wantStatus: model.Expired,
+			wantErr:    ErrStatusLocked,
+		},
+		{
+			name: "ok after canceled",
+			id:   2,
+			call: func(m *Memory, id int) error {
+				if _, err := m.Cancel(id); err != nil {
+					return err
+				}
+				return m.UpdateStatus(id, model.Ok)
+			},
+			wantStatus: model.Canceled,
+			wantErr:    ErrStatusLocked,
+		},
+		{
+			name: "fail after expired",
+			id:   1,
+			call: func(m *Memory, id int) error {
+				if err := m.UpdateStatus(id, model.Expired); err != nil {
+					return err
+				}
+				_, err := m.Fail(id, "boom", 1)
+				return err
+			},
+			wantStatus: model.Expired,
+			wantErr:    ErrStatusLocked,
+		},
+		{
+			name: "fail after canceled",
+			id:   2,
+			call: func(m *Memory, id int) error {
+				if _, err := m.Cancel(id); err != nil {
+					return err
+				}
+				_, err := m.Fail(id, "boom", 3)
+				return err
+			},
+			wantStatus: model.Canceled,
+			wantErr:    ErrStatusLocked,
+		},
+		{
+			name: "batch skips locked and unknown",
+			id:   2,
+			call: func(m *Memory, id int) error {
+				if _, err := m.Cancel(id); err != nil {
+					return err
+				}
+				return m.UpdateStatuses([]model.Message{{ID: 1}, {ID: id}, {ID: 100}}, model.Ok)
+			},
+			wantStatus: model.Canceled,
+		},
+		{
+			name:       "cancel scheduled",
+			id:         2,
+			call:       func(m *Memory, id int) error { _, err := m.Cancel(id); return err },
+			wantStatus: model.Canceled,
+		},
+		{
+			name:       "cancel new",
+			id:         1,
+			call:       func(m *Memory, id int) error { _, err := m.Cancel(id); return err },
+			wantStatus: model.New,
+			wantErr:    ErrNotScheduled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMemory(t)
+
+			if err := tt.call(m, tt.id); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+
+			msg, err := m.SelectById(tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if model.Status(msg.Status) != tt.wantStatus {
+				t.Errorf("status = %s, want %s", msg.Status, tt.wantStatus)
+			}
+
+			// Каждый переход попадает в историю
+			events, err := m.SelectEvents(tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last := events[len(events)-1]; model.Status(last.Status) != tt.wantStatus {
+				t.Errorf("last event status = %s, want %s", last.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestMemoryClaim(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int
+		due     bool // отложенное сообщение 2 уже пора отправлять
+		finish  func(m *Memory, c *Claim) error
+		wantIDs []int
+
+		wantStatuses map[int]model.Status
+	}{
+		{name: "ack", limit: 10, finish: (*Memory).Ack, wantIDs: []int{3, 1}, wantStatuses: map[int]model.Status{1: model.Processing, 3: model.Processing}},
+		{name: "release", limit: 10, finish: (*Memory).Release, wantIDs: []int{3, 1}, wantStatuses: map[int]model.Status{1: model.New, 3: model.New}},
+		{name: "limit keeps priority", limit: 1, finish: (*Memory).Ack, wantIDs: []int{3}, wantStatuses: map[int]model.Status{1: model.New, 3: model.Processing}},
+		{name: "release due scheduled", limit: 10, due: true, finish: (*Memory).Release, wantIDs: []int{3, 1, 2}, wantStatuses: map[int]model.Status{1: model.New, 2: model.Scheduled, 3: model.New}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMemory(t)
+			if tt.due {
+				m.messages[2].SendAt = time.Now().Add(-time.Second).Unix()
+			}
+
+			c, err := m.Claim(context.Background(), tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(c.Messages) != len(tt.wantIDs) {
+				t.Fatalf("claimed %d messages, want %d", len(c.Messages), len(tt.wantIDs))
+			}
+			for i, msg := range c.Messages {
+				if msg.ID != tt.wantIDs[i] {
+					t.Errorf("claimed[%d] = %d, want %d", i, msg.ID, tt.wantIDs[i])
+				}
+			}
+
+			if err = tt.finish(m, c); err != nil {
+				t.Fatal(err)
+			}
+			// Повторное завершение ничего не меняет
+			if err = m.Release(c); err != nil {
+				t.Fatal(err)
+			}
+
+			for id, want := range tt.wantStatuses {
+				msg, _ := m.SelectById(id)
+				if model.Status(msg.Status) != want {
+					t.Errorf("message %d status = %s, want %s", id, msg.Status, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemorySelectAll(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  Filter
+		wantIDs []int
+	}{
+		{name: "all", filter: Filter{}, wantIDs: []int{1, 2, 3}},
+		{name: "owner", filter: Filter{Owner: "alice"}, wantIDs: []int{1, 2}},
+		{name: "status", filter: Filter{Status: model.Scheduled.String()}, wantIDs: []int{2}},
+		{name: "content", filter: Filter{Content: "THR"}, wantIDs: []int{3}},
+		{name: "desc", filter: Filter{Desc: true}, wantIDs: []int{3, 2, 1}},
+		{name: "after id", filter: Filter{AfterID: 1, Limit: 1}, wantIDs: []int{2}},
+		{name: "after id desc", filter: Filter{AfterID: 3, Desc: true}, wantIDs: []int{2, 1}},
+	}
+
+	m := newTestMemory(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, err := m.SelectAll(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := make([]int, len(msgs))
+			for i, msg := range msgs {
+				ids[i] = msg.ID
+			}
+			if len(ids) != len(tt.wantIDs) {
+				t.Fatalf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+			for i := range ids {
+				if ids[i] != tt.wantIDs[i] {
+					t.Fatalf("ids = %v, want %v", ids, tt.wantIDs)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryInsertOnceOwners(t *testing.T) {
+	m := NewMemory(config.DataBase{})
+
+	tests := []struct {
+		name        string
+		owner       string
+		wantCreated bool
+		wantID      int
+	}{
+		{name: "alice creates", owner: "alice", wantCreated: true, wantID: 1},
+		{name: "bob creates with the same key", owner: "bob", wantCreated: true, wantID: 2},
+		{name: "alice replays", owner: "alice", wantCreated: false, wantID: 1},
+		{name: "bob replays", owner: "bob", wantCreated: false, wantID: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := model.Message{Content: "hello", From: tt.owner, To: "a@example.com", Owner: tt.owner, IdempotencyKey: "k1"}
+			created, err := m.InsertOnce(&msg, time.Hour, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if created != tt.wantCreated || msg.ID != tt.wantID {
+				t.Fatalf("created = %v, id = %d, want %v, %d", created, msg.ID, tt.wantCreated, tt.wantID)
+			}
+			if msg.Owner != tt.owner {
+				t.Fatalf("owner = %q, want %q", msg.Owner, tt.owner)
+			}
+		})
+	}
+}
+
+func TestMemoryQuota(t *testing.T) {
+	q := &Quota{Owner: "alice", Day: time.Now().UTC(), Limit: 2}
+	newMsg := func(key string) model.Message {
+		return model.Message{Content: "hello", From: "alice", To: "a@example.com", Owner: "alice", IdempotencyKey: key}
+	}
+
+	// Шаги выполняются по порядку на одном хранилище, wantCount — сообщений после шага
+	steps := []struct {
+		name      string
+		call      func(m *Memory) error
+		wantErr   error
+		wantCount int
+	}{
+		{
+			name:      "insert once",
+			call:      func(m *Memory) error { msg := newMsg("k1"); _, err := m.InsertOnce(&msg, time.Hour, q); return err },
+			wantCount: 1,
+		},
+		{
+			name:      "replay is free",
+			call:      func(m *Memory) error { msg := newMsg("k1"); _, err := m.InsertOnce(&msg, time.Hour, q); return err },
+			wantCount: 1,
+		},
+		{
+			name:      "batch over quota inserts nothing",
+			call:      func(m *Memory) error { return m.InsertBatch([]model.Message{newMsg(""), newMsg("")}, q) },
+			wantErr:   ErrQuotaExceeded,
+			wantCount: 1,
+		},
+		{
+			name:      "insert last",
+			call:      func(m *Memory) error { msg := newMsg(""); return m.Insert(&msg, q) },
+			wantCount: 2,
+		},
+		{
+			name:      "replay with quota spent",
+			call:      func(m *Memory) error { msg := newMsg("k1"); _, err := m.InsertOnce(&msg, time.Hour, q); return err },
+			wantCount: 2,
+		},
+		{
+			name:      "insert once over quota",
+			call:      func(m *Memory) error { msg := newMsg("k2"); _, err := m.InsertOnce(&msg, time.Hour, q); return err },
+			wantErr:   ErrQuotaExceeded,
+			wantCount: 2,
+		},
+		{
+			name:      "without quota",
+			call:      func(m *Memory) error { msg := newMsg(""); return m.Insert(&msg, nil) },
+			wantCount: 3,
+		},
+	}
+
+	m := NewMemory(config.DataBase{})
+	for _, step := range steps {
+		if err := step.call(m); !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: error = %v, want %v", step.name, err, step.wantErr)
+		}
+		msgs, err := m.SelectAll(Filter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != step.wantCount {
+			t.Fatalf("%s: %d messages, want %d", step.name, len(msgs), step.wantCount)
+		}
+	}
+}
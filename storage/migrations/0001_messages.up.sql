@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS messages
+(
+    "id"        bigserial NOT NULL,
+    "content"   text      NOT NULL,
+    "from"      text      NOT NULL,
+    "to"        text      NOT NULL,
+    "timestamp" bigint    NOT NULL DEFAULT extract(epoch from now()),
+    "status"    text      NOT NULL DEFAULT 'new',
+    PRIMARY KEY ("id")
+);
@@ -0,0 +1,3 @@
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS "attempts",
+    DROP COLUMN IF EXISTS "last_error";
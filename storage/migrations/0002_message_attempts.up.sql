@@ -0,0 +1,3 @@
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS "attempts"   bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS "last_error" text;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS message_events
+(
+    "id"         bigserial   NOT NULL,
+    "message_id" bigint      NOT NULL,
+    "status"     text        NOT NULL,
+    "timestamp"  timestamptz NOT NULL DEFAULT now(),
+    "instance"   text,
+    "error"      text,
+    PRIMARY KEY ("id")
+);
+
+CREATE INDEX IF NOT EXISTS message_events_message_id_idx ON message_events (message_id, id);
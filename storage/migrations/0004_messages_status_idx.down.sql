@@ -0,0 +1 @@
+DROP INDEX IF EXISTS messages_status_id_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS messages_status_id_idx ON messages (status, id);
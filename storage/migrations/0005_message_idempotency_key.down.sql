@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS messages_idempotency_key_idx;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS "idempotency_key";
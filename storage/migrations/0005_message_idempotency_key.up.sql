@@ -0,0 +1,4 @@
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS "idempotency_key" text;
+
+CREATE UNIQUE INDEX IF NOT EXISTS messages_idempotency_key_idx ON messages (idempotency_key) WHERE idempotency_key IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS message_events_notify ON message_events;
+
+DROP FUNCTION IF EXISTS notify_message_event();
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS callback_attempts;
+
+DROP TABLE IF EXISTS callbacks;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS "callback_url";
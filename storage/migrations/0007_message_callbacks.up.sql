@@ -0,0 +1,34 @@
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS "callback_url" text;
+
+CREATE TABLE IF NOT EXISTS callbacks
+(
+    "id"              bigserial   NOT NULL,
+    "message_id"      bigint      NOT NULL,
+    "url"             text        NOT NULL,
+    "status"          text        NOT NULL,
+    "error"           text,
+    "timestamp"       timestamptz NOT NULL DEFAULT now(),
+    "state"           text        NOT NULL DEFAULT 'pending',
+    "attempts"        integer     NOT NULL DEFAULT 0,
+    "next_attempt_at" timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY ("id")
+);
+
+CREATE INDEX IF NOT EXISTS callbacks_pending_idx ON callbacks (next_attempt_at, id) WHERE state = 'pending';
+CREATE INDEX IF NOT EXISTS callbacks_message_id_idx ON callbacks (message_id);
+
+CREATE TABLE IF NOT EXISTS callback_attempts
+(
+    "id"            bigserial   NOT NULL,
+    "callback_id"   bigint      NOT NULL REFERENCES callbacks ("id") ON DELETE CASCADE,
+    "message_id"    bigint      NOT NULL,
+    "attempt"       integer     NOT NULL,
+    "state"         text        NOT NULL,
+    "response_code" integer,
+    "error"         text,
+    "timestamp"     timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY ("id")
+);
+
+CREATE INDEX IF NOT EXISTS callback_attempts_message_id_idx ON callback_attempts (message_id, id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS messages_scheduled_send_at_idx;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS "send_at";
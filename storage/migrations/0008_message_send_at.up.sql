@@ -0,0 +1,4 @@
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS "send_at" bigint;
+
+CREATE INDEX IF NOT EXISTS messages_scheduled_send_at_idx ON messages (send_at) WHERE status = 'scheduled';
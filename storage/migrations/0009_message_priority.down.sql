@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS messages_new_priority_idx;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS "priority";
@@ -0,0 +1,4 @@
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS "priority" smallint NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS messages_new_priority_idx ON messages (priority DESC, id) WHERE status = 'new';
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS messages_pending_expires_at_idx;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS "expires_at";
@@ -0,0 +1,5 @@
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS "expires_at" bigint;
+
+CREATE INDEX IF NOT EXISTS messages_pending_expires_at_idx ON messages (expires_at)
+    WHERE status IN ('new', 'scheduled', 'processing');
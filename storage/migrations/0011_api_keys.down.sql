@@ -0,0 +1,29 @@
+CREATE OR REPLACE FUNCTION notify_message_event() RETURNS trigger AS
+$$
+DECLARE
+    msg_from text;
+    msg_to   text;
+BEGIN
+    SELECT "from", "to" INTO msg_from, msg_to FROM messages WHERE id = NEW.message_id;
+
+    PERFORM pg_notify('message_events', json_build_object(
+            'id', NEW.id,
+            'message_id', NEW.message_id,
+            'status', NEW.status,
+            'timestamp', NEW.timestamp,
+            'instance', NEW.instance,
+            'error', left(NEW.error, 1000),
+            'from', msg_from,
+            'to', msg_to
+        )::text);
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP INDEX IF EXISTS messages_owner_id_idx;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS "owner";
+
+DROP TABLE IF EXISTS api_keys;
@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    "id"         bigserial   NOT NULL,
+    "name"       text        NOT NULL,
+    "key_hash"   text        NOT NULL,
+    "scopes"     text[]      NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    "revoked_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS api_keys_key_hash_idx ON api_keys (key_hash);
+
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS "owner" text;
+
+CREATE INDEX IF NOT EXISTS messages_owner_id_idx ON messages (owner, id);
+
+CREATE OR REPLACE FUNCTION notify_message_event() RETURNS trigger AS
+$$
+DECLARE
+    msg_from  text;
+    msg_to    text;
+    msg_owner text;
+BEGIN
+    SELECT "from", "to", "owner" INTO msg_from, msg_to, msg_owner FROM messages WHERE id = NEW.message_id;
+
+    PERFORM pg_notify('message_events', json_build_object(
+            'id', NEW.id,
+            'message_id', NEW.message_id,
+            'status', NEW.status,
+            'timestamp', NEW.timestamp,
+            'instance', NEW.instance,
+            'error', left(NEW.error, 1000),
+            'from', msg_from,
+            'to', msg_to,
+            'owner', msg_owner
+        )::text);
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS quotas;
+
+ALTER TABLE api_keys
+    DROP COLUMN IF EXISTS "daily_quota";
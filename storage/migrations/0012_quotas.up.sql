@@ -0,0 +1,10 @@
+ALTER TABLE api_keys
+    ADD COLUMN IF NOT EXISTS "daily_quota" integer NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS quotas
+(
+    "owner" text    NOT NULL,
+    "day"   date    NOT NULL,
+    "used"  integer NOT NULL,
+    PRIMARY KEY ("owner", "day")
+);
@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS messages_owner_idempotency_key_idx;
+
+UPDATE messages
+SET idempotency_key = NULL
+WHERE id IN (SELECT id
+             FROM (SELECT id, row_number() OVER (PARTITION BY idempotency_key ORDER BY id DESC) AS n
+                   FROM messages
+                   WHERE idempotency_key IS NOT NULL) AS keys
+             WHERE n > 1);
+
+CREATE UNIQUE INDEX IF NOT EXISTS messages_idempotency_key_idx ON messages (idempotency_key) WHERE idempotency_key IS NOT NULL;
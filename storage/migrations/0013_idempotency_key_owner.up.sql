@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS messages_idempotency_key_idx;
+
+CREATE UNIQUE INDEX IF NOT EXISTS messages_owner_idempotency_key_idx
+    ON messages ((coalesce(owner, '')), idempotency_key) WHERE idempotency_key IS NOT NULL;
@@ -0,0 +1,20 @@
+DELETE FROM quotas
+WHERE owner LIKE 'jwt:%';
+
+UPDATE quotas
+SET owner = substr(owner, 5)
+WHERE owner LIKE 'key:%';
+
+UPDATE messages
+SET idempotency_key = NULL
+WHERE owner LIKE 'jwt:%'
+  AND idempotency_key IS NOT NULL
+  AND EXISTS (SELECT 1
+              FROM messages AS m
+              WHERE m.owner = 'key:' || substr(messages.owner, 5)
+                AND m.idempotency_key = messages.idempotency_key);
+
+UPDATE messages
+SET owner = substr(owner, 5)
+WHERE owner LIKE 'key:%'
+   OR owner LIKE 'jwt:%';
@@ -0,0 +1,12 @@
+UPDATE messages
+SET owner = CASE WHEN owner IN (SELECT name FROM api_keys) THEN 'key:' ELSE 'jwt:' END || owner
+WHERE owner IS NOT NULL
+  AND owner <> 'admin'
+  AND owner NOT LIKE 'key:%'
+  AND owner NOT LIKE 'jwt:%';
+
+UPDATE quotas
+SET owner = CASE WHEN owner IN (SELECT name FROM api_keys) THEN 'key:' ELSE 'jwt:' END || owner
+WHERE owner <> 'admin'
+  AND owner NOT LIKE 'key:%'
+  AND owner NOT LIKE 'jwt:%';
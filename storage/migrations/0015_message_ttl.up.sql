@@ -0,0 +1,6 @@
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS "ttl" bigint;
+
+UPDATE messages
+SET ttl = expires_at - greatest("timestamp", coalesce(send_at, 0))
+WHERE expires_at IS NOT NULL;
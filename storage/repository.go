@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"messaggio/config"
+	"messaggio/model"
+)
+
+const memoryAddr = "memory://"
+
+type Repository interface {
+	Close()
+
+	Insert(msg *model.Message, q *Quota) error
+	InsertOnce(msg *model.Message, retention time.Duration, q *Quota) (bool, error)
+	InsertBatch(msgs []model.Message, q *Quota) error
+
+	SelectAll(f Filter) ([]model.Message, error)
+	SelectById(id int) (model.Message, error)
+	SelectNew() ([]model.Message, error)
+	SelectEvents(id int) ([]model.Event, error)
+	CountByStatus() (map[model.Status]int, error)
+
+	Claim(ctx context.Context, limit int) (*Claim, error)
+	Ack(c *Claim) error
+	Release(c *Claim) error
+
+	UpdateStatus(id int, status model.Status) error
+	UpdateStatuses(msgs []model.Message, status model.Status) error
+	Fail(id int, reason string, maxAttempts int) (model.Status, error)
+	Cancel(id int) (model.Message, error)
+	Expire(ctx context.Context, limit int) (map[model.Status]int, error)
+
+	ListenEvents(ctx context.Context, fn func(model.Event)) error
+
+	ClaimCallbacks(ctx context.Context, limit int, lease time.Duration) ([]model.Callback, error)
+	SaveCallbackAttempt(cb *model.Callback, attempt *model.CallbackAttempt) error
+	SelectCallbackAttempts(messageID int) ([]model.CallbackAttempt, error)
+
+	CreateAPIKey(key *model.APIKey) error
+	SelectAPIKey(hash string) (model.APIKey, error)
+	SelectAPIKeys() ([]model.APIKey, error)
+	RevokeAPIKey(id int) error
+}
+
+// Quota — суточная квота владельца, списываемая в той же транзакции, что и добавление сообщений:
+// списываются только действительно добавленные сообщения, повтор по ключу идемпотентности бесплатен.
+// nil — без квоты.
+type Quota struct {
+	Owner string
+	Day   time.Time
+	Limit int
+}
+
+type Claim struct {
+	Messages []model.Message
+	commit   func() error
+	rollback func() error
+}
+
+// Open выбирает реализацию по адресу базы: memory:// — хранилище в памяти процесса, иначе PostgreSQL.
+func Open(ctx context.Context, cfg config.DataBase) (Repository, error) {
+	if cfg.Addr == memoryAddr {
+		return NewMemory(cfg), nil
+	}
+	return New(ctx, cfg)
+}
+
+// lockedStatuses не перезаписываются UpdateStatus и Fail: sweeper или отмена могли опередить
+// receiver, и отменённое или просроченное сообщение не должно стать ok, new или error.
+var lockedStatuses = []model.Status{model.Canceled, model.Expired}
+
+// initStatus выставляет начальный статус: сообщения с send_at в будущем ждут в scheduled.
+func initStatus(msg *model.Message) {
+	msg.Status = model.New.String()
+	if msg.SendAt > time.Now().Unix() {
+		msg.Status = model.Scheduled.String()
+	}
+}
+
+// finish завершает claim один раз, повторные Ack/Release ничего не делают.
+func (c *Claim) finish(fn func() error) error {
+	if fn == nil {
+		return nil
+	}
+	c.commit, c.rollback = nil, nil
+	return fn()
+}
+
+var (
+	_ Repository = (*Storage)(nil)
+	_ Repository = (*Memory)(nil)
+)
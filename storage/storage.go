@@ -2,9 +2,14 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
 
 	"github.com/go-pg/pg/v10"
 	"github.com/go-pg/pg/v10/orm"
+	log "github.com/sirupsen/logrus"
 	"messaggio/config"
 	"messaggio/model"
 )
@@ -14,70 +19,585 @@ type Storage struct {
 	cfg config.DataBase
 }
 
+// New подключается к базе и применяет недостающие миграции.
 func New(ctx context.Context, cfg config.DataBase) (*Storage, error) {
+	s, err := Connect(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := s.MigrateUp(ctx)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	for _, m := range migrations {
+		log.Infof("migration %d_%s applied", m.Version, m.Name)
+	}
+
+	return s, nil
+}
+
+// Connect подключается к базе без применения миграций.
+func Connect(ctx context.Context, cfg config.DataBase) (*Storage, error) {
 	opt, err := pg.ParseURL(cfg.Addr)
 	if err != nil {
 		return nil, err
 	}
 
+	if cfg.PoolSize > 0 {
+		opt.PoolSize = cfg.PoolSize
+	}
+
 	db := pg.Connect(opt)
 
 	if err := db.Ping(ctx); err != nil {
 		return nil, err
 	}
 
-	s := &Storage{
+	return &Storage{
 		cfg: cfg,
 		db:  db,
-	}
-
-	return s, s.Create()
+	}, nil
 }
 
 func (s *Storage) Close() {
 	_ = s.db.Close()
 }
 
-func (s *Storage) Create() error {
-	return s.db.Model((*model.Message)(nil)).CreateTable(&orm.CreateTableOptions{
-		IfNotExists: true,
+// addEvents записывает переход сообщений в новый статус в историю.
+func (s *Storage) addEvents(db orm.DB, status model.Status, reason string, ids ...int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	events := make([]model.Event, len(ids))
+	for i, id := range ids {
+		events[i] = model.Event{
+			MessageID: id,
+			Status:    status.String(),
+			Instance:  s.cfg.Instance,
+			Error:     reason,
+		}
+	}
+
+	if _, err := db.Model(&events).Insert(); err != nil {
+		return err
+	}
+
+	if !status.Final() {
+		return nil
+	}
+
+	// Окончательный статус ставит в очередь уведомление на callback_url, если он задан
+	_, err := db.Exec(`
+		INSERT INTO callbacks (message_id, url, status, error)
+		SELECT id, callback_url, ?, ? FROM messages WHERE id IN (?) AND coalesce(callback_url, '') <> ''`,
+		status, reason, pg.In(ids))
+	return err
+}
+
+func (s *Storage) Insert(msg *model.Message, q *Quota) error {
+	initStatus(msg)
+	return wrapError(s.db.RunInTransaction(s.db.Context(), func(tx *pg.Tx) error {
+		if _, err := tx.Model(msg).Returning("id, status, timestamp").Insert(); err != nil {
+			return err
+		}
+		if err := consumeQuota(tx, q, 1); err != nil {
+			return err
+		}
+
+		return s.addEvents(tx, model.Status(msg.Status), "", msg.ID)
+	}))
+}
+
+// InsertOnce добавляет сообщение с ключом идемпотентности. Если сообщение того же владельца
+// с таким ключом уже создано в пределах retention, msg заполняется им и возвращается false, квота не списывается.
+func (s *Storage) InsertOnce(msg *model.Message, retention time.Duration, q *Quota) (bool, error) {
+	initStatus(msg)
+	var created bool
+	err := s.db.RunInTransaction(s.db.Context(), func(tx *pg.Tx) error {
+		// Ключи старше retention освобождаются для повторного использования. Ключи
+		// уникальны в пределах владельца, чужие сообщения не затрагиваются.
+		_, err := tx.Model((*model.Message)(nil)).
+			Set("idempotency_key = NULL").
+			Where("coalesce(owner, '') = ?", msg.Owner).
+			Where("idempotency_key = ?", msg.IdempotencyKey).
+			Where("\"timestamp\" < extract(epoch from now()) - ?", int64(retention.Seconds())).
+			Update()
+		if err != nil {
+			return err
+		}
+
+		// INSERT ... RETURNING в одну модель при конфликте не возвращает строк, и go-pg отвечает pg.ErrNoRows
+		_, err = tx.Model(msg).
+			OnConflict("((coalesce(owner, '')), idempotency_key) WHERE idempotency_key IS NOT NULL DO NOTHING").
+			Returning("id, status, timestamp").
+			Insert()
+		if errors.Is(err, pg.ErrNoRows) {
+			return tx.Model(msg).
+				Where("coalesce(owner, '') = ?", msg.Owner).
+				Where("idempotency_key = ?", msg.IdempotencyKey).
+				Select()
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = consumeQuota(tx, q, 1); err != nil {
+			return err
+		}
+
+		created = true
+		return s.addEvents(tx, model.Status(msg.Status), "", msg.ID)
 	})
+	return created, wrapError(err)
 }
 
-func (s *Storage) Insert(msg *model.Message) error {
-	_, err := s.db.Model(msg).Returning("id, status, timestamp").Insert()
-	return err
+// InsertBatch добавляет сообщения одним multi-row INSERT.
+func (s *Storage) InsertBatch(msgs []model.Message, q *Quota) error {
+	for i := range msgs {
+		initStatus(&msgs[i])
+	}
+
+	return wrapError(s.db.RunInTransaction(s.db.Context(), func(tx *pg.Tx) error {
+		if _, err := tx.Model(&msgs).Returning("id, status, timestamp").Insert(); err != nil {
+			return err
+		}
+		if err := consumeQuota(tx, q, len(msgs)); err != nil {
+			return err
+		}
+
+		ids := make(map[model.Status][]int)
+		for _, msg := range msgs {
+			ids[model.Status(msg.Status)] = append(ids[model.Status(msg.Status)], msg.ID)
+		}
+
+		for status, statusIDs := range ids {
+			if err := s.addEvents(tx, status, "", statusIDs...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
 }
 
-func (s *Storage) SelectAll(status string, page, limit int) ([]model.Message, error) {
+type Filter struct {
+	Owner   string
+	Status  string
+	From    string
+	To      string
+	Content string
+	Since   int64
+	Until   int64
+	AfterID int
+	Desc    bool
+	Limit   int
+}
+
+// SelectAll возвращает сообщения с keyset-пагинацией по id: AfterID — последний id предыдущей страницы.
+func (s *Storage) SelectAll(f Filter) ([]model.Message, error) {
 	var msgs []model.Message
 	query := s.db.Model(&msgs)
-	if status != "" {
-		query.Where("status = ?", status)
+	if f.Owner != "" {
+		query.Where("owner = ?", f.Owner)
+	}
+	if f.Status != "" {
+		query.Where("status = ?", f.Status)
+	}
+	if f.From != "" {
+		query.Where("\"from\" = ?", f.From)
+	}
+	if f.To != "" {
+		query.Where("\"to\" = ?", f.To)
+	}
+	if f.Content != "" {
+		query.Where("content ILIKE ?", "%"+likeEscaper.Replace(f.Content)+"%")
+	}
+	if f.Since != 0 {
+		query.Where("\"timestamp\" >= ?", f.Since)
 	}
-	query.Offset((page - 1) * limit).Limit(limit).Order("id")
-	err := query.Select()
-	return msgs, err
+	if f.Until != 0 {
+		query.Where("\"timestamp\" <= ?", f.Until)
+	}
+
+	if f.Desc {
+		if f.AfterID != 0 {
+			query.Where("id < ?", f.AfterID)
+		}
+		query.Order("id DESC")
+	} else {
+		if f.AfterID != 0 {
+			query.Where("id > ?", f.AfterID)
+		}
+		query.Order("id")
+	}
+
+	err := query.Limit(f.Limit).Select()
+	return msgs, wrapError(err)
 }
 
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 func (s *Storage) SelectById(id int) (model.Message, error) {
 	var msg model.Message
 	err := s.db.Model(&msg).Where("id = ?", id).Select()
-	return msg, err
+	return msg, wrapError(err)
 }
 
 func (s *Storage) SelectNew() ([]model.Message, error) {
 	var msgs []model.Message
 	err := s.db.Model(&msgs).Where("status = ?", model.New).Order("id").Select()
-	return msgs, err
+	return msgs, wrapError(err)
 }
 
+// Claim блокирует до limit новых сообщений и запланированных, чьё send_at наступило (FOR UPDATE SKIP LOCKED),
+// начиная с высокого приоритета и пропуская просроченные, и переводит их в processing в рамках одной транзакции. Транзакция остаётся открытой до вызова Ack или Release.
+func (s *Storage) Claim(ctx context.Context, limit int) (*Claim, error) {
+	tx, err := s.db.BeginContext(ctx)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	c := &Claim{commit: tx.Commit, rollback: tx.Rollback}
+	err = tx.ModelContext(ctx, &c.Messages).
+		WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+			return q.Where("status = ?", model.New).
+				WhereOr("status = ? AND send_at <= extract(epoch from now())", model.Scheduled), nil
+		}).
+		Where("expires_at IS NULL OR expires_at > extract(epoch from now())").
+		Order("priority DESC", "id").
+		Limit(limit).
+		For("UPDATE SKIP LOCKED").
+		Select()
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, wrapError(err)
+	}
+
+	if len(c.Messages) == 0 {
+		return c, s.Release(c)
+	}
+
+	if _, err = tx.ModelContext(ctx, &c.Messages).Set("status = ?", model.Processing).WherePK().Update(); err != nil {
+		_ = tx.Rollback()
+		return nil, wrapError(err)
+	}
+
+	ids := make([]int, len(c.Messages))
+	for i := range c.Messages {
+		c.Messages[i].Status = model.Processing.String()
+		ids[i] = c.Messages[i].ID
+	}
+
+	if err = s.addEvents(tx, model.Processing, "", ids...); err != nil {
+		_ = tx.Rollback()
+		return nil, wrapError(err)
+	}
+
+	return c, nil
+}
+
+func (s *Storage) Ack(c *Claim) error {
+	return c.finish(c.commit)
+}
+
+func (s *Storage) Release(c *Claim) error {
+	return c.finish(c.rollback)
+}
+
+// UpdateStatus меняет статус сообщения. Для отменённого или просроченного сообщения возвращается ErrStatusLocked.
 func (s *Storage) UpdateStatus(id int, status model.Status) error {
-	_, err := s.db.Model(&model.Message{ID: id}).Set("status = ?", status).WherePK().Update()
-	return err
+	return wrapError(s.db.RunInTransaction(s.db.Context(), func(tx *pg.Tx) error {
+		res, err := tx.Model(&model.Message{ID: id}).Set("status = ?", status).WherePK().
+			Where("status NOT IN (?)", pg.In(lockedStatuses)).
+			Update()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return lockedOrMissing(tx, id)
+		}
+
+		return s.addEvents(tx, status, "", id)
+	}))
+}
+
+// lockedOrMissing объясняет, почему смена статуса не затронула сообщение id:
+// ErrStatusLocked, если оно отменено или просрочено, иначе pg.ErrNoRows.
+func lockedOrMissing(db orm.DB, id int) error {
+	exists, err := db.Model((*model.Message)(nil)).Where("id = ?", id).Exists()
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrStatusLocked
+	}
+	return pg.ErrNoRows
 }
 
 func (s *Storage) UpdateStatuses(msgs []model.Message, status model.Status) error {
-	_, err := s.db.Model(&msgs).Set("status = ?", status).WherePK().Update()
-	return err
+	ids := make([]int, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID
+	}
+
+	return wrapError(s.db.RunInTransaction(s.db.Context(), func(tx *pg.Tx) error {
+		// Отменённые и просроченные сообщения пропускаются, история пишется только для изменённых
+		var rows []struct {
+			ID int
+		}
+		_, err := tx.Query(&rows, `
+			UPDATE messages SET status = ?
+			WHERE id IN (?) AND status NOT IN (?)
+			RETURNING id`,
+			status, pg.In(ids), pg.In(lockedStatuses))
+		if err != nil {
+			return err
+		}
+
+		updated := make([]int, len(rows))
+		for i, row := range rows {
+			updated[i] = row.ID
+		}
+		return s.addEvents(tx, status, "", updated...)
+	}))
+}
+
+func (s *Storage) SelectEvents(id int) ([]model.Event, error) {
+	var events []model.Event
+	err := s.db.Model(&events).Where("message_id = ?", id).Order("id").Select()
+	return events, wrapError(err)
+}
+
+// Fail увеличивает счётчик попыток и сохраняет текст ошибки. Пока попытки не исчерпаны,
+// сообщение возвращается в new и будет отправлено повторно, иначе переводится в error.
+// Отменённое или просроченное сообщение не меняется, возвращается ErrStatusLocked.
+func (s *Storage) Fail(id int, reason string, maxAttempts int) (model.Status, error) {
+	var msg model.Message
+	err := s.db.RunInTransaction(s.db.Context(), func(tx *pg.Tx) error {
+		// UPDATE ... RETURNING в одну модель без подходящих строк возвращает pg.ErrNoRows
+		_, err := tx.Model(&msg).
+			Set("attempts = attempts + 1").
+			Set("last_error = ?", reason).
+			Set("status = CASE WHEN attempts + 1 >= ? THEN ? ELSE ? END", maxAttempts, model.Error, model.New).
+			Where("id = ?", id).
+			Where("status NOT IN (?)", pg.In(lockedStatuses)).
+			Returning("status").
+			Update()
+		if errors.Is(err, pg.ErrNoRows) {
+			return lockedOrMissing(tx, id)
+		}
+		if err != nil {
+			return err
+		}
+
+		return s.addEvents(tx, model.Status(msg.Status), reason, id)
+	})
+	return model.Status(msg.Status), wrapError(err)
+}
+
+// Cancel отменяет запланированное сообщение. Если сообщение уже ушло в отправку, возвращается ErrNotScheduled.
+func (s *Storage) Cancel(id int) (model.Message, error) {
+	var msg model.Message
+	err := s.db.RunInTransaction(s.db.Context(), func(tx *pg.Tx) error {
+		if err := tx.Model(&msg).Where("id = ?", id).For("UPDATE").Select(); err != nil {
+			return err
+		}
+		if msg.Status != model.Scheduled.String() {
+			return ErrNotScheduled
+		}
+
+		msg.Status = model.Canceled.String()
+		if _, err := tx.Model(&msg).Set("status = ?", model.Canceled).WherePK().Update(); err != nil {
+			return err
+		}
+
+		return s.addEvents(tx, model.Canceled, "", id)
+	})
+	return msg, wrapError(err)
+}
+
+// Expire переводит в expired до limit сообщений в new, scheduled или processing, чей expires_at наступил,
+// и возвращает их количество по предыдущему статусу. Сообщения, заблокированные Claim, пропускаются до следующего вызова.
+func (s *Storage) Expire(ctx context.Context, limit int) (map[model.Status]int, error) {
+	var rows []struct {
+		ID     int
+		Status model.Status
+	}
+	err := s.db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		_, err := tx.QueryContext(ctx, &rows, `
+			WITH expired AS (
+				SELECT id, status FROM messages
+				WHERE status IN (?) AND expires_at <= extract(epoch from now())
+				ORDER BY expires_at
+				LIMIT ?
+				FOR UPDATE SKIP LOCKED
+			)
+			UPDATE messages SET status = ? FROM expired WHERE messages.id = expired.id
+			RETURNING expired.id, expired.status`,
+			pg.In([]model.Status{model.New, model.Scheduled, model.Processing}), limit, model.Expired)
+		if err != nil {
+			return err
+		}
+
+		ids := make([]int, len(rows))
+		for i, row := range rows {
+			ids[i] = row.ID
+		}
+		return s.addEvents(tx, model.Expired, "", ids...)
+	})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	counts := make(map[model.Status]int)
+	for _, row := range rows {
+		counts[row.Status]++
+	}
+	return counts, nil
+}
+
+func (s *Storage) CountByStatus() (map[model.Status]int, error) {
+	var rows []struct {
+		Status model.Status
+		Count  int
+	}
+	err := s.db.Model((*model.Message)(nil)).
+		Column("status").
+		ColumnExpr("count(*) AS count").
+		Group("status").
+		Select(&rows)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	counts := make(map[model.Status]int, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+// ClaimCallbacks выбирает до limit уведомлений, срок отправки которых наступил, и откладывает их на lease,
+// чтобы другие экземпляры не взяли их повторно. Результат попытки сохраняется через SaveCallbackAttempt.
+func (s *Storage) ClaimCallbacks(ctx context.Context, limit int, lease time.Duration) ([]model.Callback, error) {
+	var callbacks []model.Callback
+	_, err := s.db.QueryContext(ctx, &callbacks, `
+		UPDATE callbacks SET next_attempt_at = now() + make_interval(secs => ?)
+		WHERE id IN (
+			SELECT id FROM callbacks
+			WHERE state = ? AND next_attempt_at <= now()
+			ORDER BY next_attempt_at, id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		lease.Seconds(), model.CallbackPending, limit)
+	return callbacks, wrapError(err)
+}
+
+// SaveCallbackAttempt записывает попытку и новое состояние уведомления.
+func (s *Storage) SaveCallbackAttempt(cb *model.Callback, attempt *model.CallbackAttempt) error {
+	return wrapError(s.db.RunInTransaction(s.db.Context(), func(tx *pg.Tx) error {
+		_, err := tx.Model(cb).Column("state", "attempts", "next_attempt_at").WherePK().Update()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Model(attempt).Insert()
+		return err
+	}))
+}
+
+func (s *Storage) SelectCallbackAttempts(messageID int) ([]model.CallbackAttempt, error) {
+	var attempts []model.CallbackAttempt
+	err := s.db.Model(&attempts).Where("message_id = ?", messageID).Order("id").Select()
+	return attempts, wrapError(err)
+}
+
+func (s *Storage) CreateAPIKey(key *model.APIKey) error {
+	_, err := s.db.Model(key).Returning("id, created_at").Insert()
+	return wrapError(err)
+}
+
+// SelectAPIKey ищет действующий ключ по хэшу, отозванные ключи не находятся.
+func (s *Storage) SelectAPIKey(hash string) (model.APIKey, error) {
+	var key model.APIKey
+	err := s.db.Model(&key).Where("key_hash = ?", hash).Where("revoked_at IS NULL").Select()
+	return key, wrapError(err)
+}
+
+func (s *Storage) SelectAPIKeys() ([]model.APIKey, error) {
+	var keys []model.APIKey
+	err := s.db.Model(&keys).Order("id").Select()
+	return keys, wrapError(err)
+}
+
+func (s *Storage) RevokeAPIKey(id int) error {
+	res, err := s.db.Model((*model.APIKey)(nil)).
+		Set("revoked_at = now()").
+		Where("id = ?", id).
+		Where("revoked_at IS NULL").
+		Update()
+	if err != nil {
+		return wrapError(err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// consumeQuota списывает n сообщений из квоты q в транзакции добавления. Если после списания
+// будет превышен лимит, возвращается ErrQuotaExceeded и транзакция откатывается вместе с сообщениями.
+func consumeQuota(db orm.DB, q *Quota, n int) error {
+	if q == nil {
+		return nil
+	}
+	if n > q.Limit {
+		return ErrQuotaExceeded
+	}
+
+	res, err := db.Exec(`
+		INSERT INTO quotas (owner, day, used) VALUES (?, ?, ?)
+		ON CONFLICT (owner, day) DO UPDATE SET used = quotas.used + excluded.used
+		WHERE quotas.used + excluded.used <= ?`,
+		q.Owner, q.Day.Format(time.DateOnly), n, q.Limit)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// eventsChannel — канал LISTEN/NOTIFY, в который триггер на message_events публикует каждый переход статуса.
+const eventsChannel = "message_events"
+
+// ListenEvents вызывает fn для каждого нового события из message_events до отмены ctx.
+func (s *Storage) ListenEvents(ctx context.Context, fn func(model.Event)) error {
+	ln := s.db.Listen(ctx, eventsChannel)
+	defer ln.Close()
+
+	ch := ln.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case n, ok := <-ch:
+			if !ok {
+				return errors.New("listener closed")
+			}
+
+			var event model.Event
+			if err := json.Unmarshal([]byte(n.Payload), &event); err != nil {
+				log.Error(err)
+				continue
+			}
+			fn(event)
+		}
+	}
 }
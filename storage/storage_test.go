@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"messaggio/config"
+	"messaggio/model"
+)
+
+// newTestStorage подключается к PostgreSQL из TEST_DATABASE_ADDRESS и очищает таблицы сообщений.
+// Без переменной тесты PostgreSQL пропускаются.
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	addr := os.Getenv("TEST_DATABASE_ADDRESS")
+	if addr == "" {
+		t.Skip("TEST_DATABASE_ADDRESS is not set")
+	}
+
+	s, err := New(context.Background(), config.DataBase{Addr: addr, Instance: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	if _, err = s.db.Exec(`TRUNCATE messages, message_events, callbacks, quotas RESTART IDENTITY CASCADE`); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStorageInsertOnce(t *testing.T) {
+	s := newTestStorage(t)
+
+	tests := []struct {
+		name        string
+		owner       string
+		wantCreated bool
+		wantID      int
+	}{
+		{name: "alice creates", owner: "alice", wantCreated: true, wantID: 1},
+		{name: "bob creates with the same key", owner: "bob", wantCreated: true, wantID: 2},
+		{name: "alice replays", owner: "alice", wantCreated: false, wantID: 1},
+		{name: "bob replays", owner: "bob", wantCreated: false, wantID: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := model.Message{Content: "hello", From: tt.owner, To: "a@example.com", Owner: tt.owner, IdempotencyKey: "k1"}
+			created, err := s.InsertOnce(&msg, time.Hour, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if created != tt.wantCreated || msg.ID != tt.wantID {
+				t.Fatalf("created = %v, id = %d, want %v, %d", created, msg.ID, tt.wantCreated, tt.wantID)
+			}
+			if msg.Owner != tt.owner || msg.Status != model.New.String() {
+				t.Fatalf("owner = %q, status = %s, want %q, %s", msg.Owner, msg.Status, tt.owner, model.New)
+			}
+		})
+	}
+
+	// Повтор не пишет историю
+	events, err := s.SelectEvents(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("%d events, want 1", len(events))
+	}
+}
+
+func TestStorageFailLocked(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     model.Status
+		wantErr    error
+		wantStatus model.Status
+	}{
+		{name: "processing", status: model.Processing, wantStatus: model.Error},
+		{name: "expired", status: model.Expired, wantErr: ErrStatusLocked, wantStatus: model.Expired},
+		{name: "canceled", status: model.Canceled, wantErr: ErrStatusLocked, wantStatus: model.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStorage(t)
+			msg := model.Message{Content: "hello", From: "alice", To: "a@example.com"}
+			if err := s.Insert(&msg, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.UpdateStatus(msg.ID, tt.status); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := s.Fail(msg.ID, "boom", 1); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if _, err := s.Fail(100, "boom", 1); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("unknown id error = %v, want %v", err, ErrNotFound)
+			}
+
+			stored, err := s.SelectById(msg.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if model.Status(stored.Status) != tt.wantStatus {
+				t.Errorf("status = %s, want %s", stored.Status, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package sweeper
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"messaggio/config"
+	"messaggio/prometheus"
+	"messaggio/storage"
+)
+
+// Worker периодически переводит в expired сообщения, срок жизни которых истёк до доставки.
+type Worker struct {
+	storage    storage.Repository
+	prometheus *prometheus.Prometheus
+	cfg        config.Broker
+}
+
+func New(s storage.Repository, p *prometheus.Prometheus, cfg config.Broker) *Worker {
+	return &Worker{
+		storage:    s,
+		prometheus: p,
+		cfg:        cfg,
+	}
+}
+
+func (w *Worker) Run(ctx context.Context) error {
+	work := context.WithoutCancel(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("sweeper stopped")
+			return nil
+		case <-time.After(w.cfg.ExpireInterval):
+			// Пачками, пока не останется просроченных
+			for ctx.Err() == nil {
+				if w.sweep(work) < w.cfg.BatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// sweep переводит в expired одну пачку и возвращает её размер.
+func (w *Worker) sweep(ctx context.Context) int {
+	counts, err := w.storage.Expire(ctx, w.cfg.BatchSize)
+	if err != nil {
+		log.Error(err)
+		return 0
+	}
+
+	total := 0
+	for status, n := range counts {
+		w.prometheus.ExpiredMessageCounter.WithLabelValues(status.String()).Add(float64(n))
+		total += n
+	}
+	if total > 0 {
+		log.Infof("%d messages expired", total)
+	}
+	return total
+}